@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"blueprint"
+)
+
+// defaultElasticSigma is the smoothing of elastic distortion fields, in pixels.
+const defaultElasticSigma = 4.0
+
+// ImageShape describes how a dense row holds an image: one plane of
+// Height rows of Width pixels per channel, channel after channel.
+type ImageShape struct {
+	Width, Height, Channels int
+}
+
+// AugmentConfig enables random perturbations of the training images. Each
+// field left at zero disables its perturbation.
+type AugmentConfig struct {
+	// MaxShift is the largest translation in pixels along each axis.
+	MaxShift int `json:"max_shift,omitempty"`
+	// MaxRotation is the largest rotation in degrees either way.
+	MaxRotation float64 `json:"max_rotation,omitempty"`
+	// Elastic is the strength in pixels of a smooth random displacement
+	// field, smoothed with a gaussian of ElasticSigma pixels (default 4).
+	Elastic      float64 `json:"elastic,omitempty"`
+	ElasticSigma float64 `json:"elastic_sigma,omitempty"`
+	// Noise is the standard deviation of gaussian noise added to every pixel.
+	Noise float64 `json:"noise,omitempty"`
+	// Cutout is the side of a square patch blanked at a random position.
+	Cutout int `json:"cutout,omitempty"`
+	// Seed fixes the perturbations; 0 uses the run's seed. Round k of a run
+	// always sees the same perturbations, also after resuming.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Validate checks that no option is negative.
+func (c AugmentConfig) Validate() error {
+	if c.MaxShift < 0 || c.MaxRotation < 0 || c.Elastic < 0 || c.ElasticSigma < 0 || c.Noise < 0 || c.Cutout < 0 {
+		return fmt.Errorf("augmentation options must not be negative")
+	}
+	return nil
+}
+
+// Enabled reports whether any perturbation is switched on.
+func (c AugmentConfig) Enabled() bool {
+	return c.MaxShift > 0 || c.MaxRotation > 0 || c.Elastic > 0 || c.Noise > 0 || c.Cutout > 0
+}
+
+// Augment perturbs the image in pixels, whose values are in [0, 1], in place.
+// Shifts, rotation and elastic distortion are combined into one resampling;
+// noise and cutout are applied afterwards.
+func (c AugmentConfig) Augment(pixels []float32, shape ImageShape, rng *rand.Rand) {
+	w, h := shape.Width, shape.Height
+	plane := w * h
+
+	if c.MaxShift > 0 || c.MaxRotation > 0 || c.Elastic > 0 {
+		dx := float64(rng.Intn(2*c.MaxShift+1) - c.MaxShift)
+		dy := float64(rng.Intn(2*c.MaxShift+1) - c.MaxShift)
+		angle := (2*rng.Float64() - 1) * c.MaxRotation * math.Pi / 180
+		sin, cos := math.Sincos(angle)
+
+		var fieldX, fieldY []float64
+		if c.Elastic > 0 {
+			sigma := c.ElasticSigma
+			if sigma == 0 {
+				sigma = defaultElasticSigma
+			}
+			fieldX = elasticField(w, h, c.Elastic, sigma, rng)
+			fieldY = elasticField(w, h, c.Elastic, sigma, rng)
+		}
+
+		// Sample every output pixel from where the inverse transform maps it
+		cx, cy := float64(w-1)/2, float64(h-1)/2
+		source := make([]float32, len(pixels))
+		copy(source, pixels)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				px, py := float64(x)-dx-cx, float64(y)-dy-cy
+				sx := cos*px + sin*py + cx
+				sy := -sin*px + cos*py + cy
+				if fieldX != nil {
+					sx += fieldX[y*w+x]
+					sy += fieldY[y*w+x]
+				}
+				for ch := 0; ch < shape.Channels; ch++ {
+					pixels[ch*plane+y*w+x] = bilinear(source[ch*plane:(ch+1)*plane], w, h, sx, sy)
+				}
+			}
+		}
+	}
+
+	if c.Noise > 0 {
+		for i, v := range pixels {
+			pixels[i] = float32(math.Min(1, math.Max(0, float64(v)+rng.NormFloat64()*c.Noise)))
+		}
+	}
+
+	if c.Cutout > 0 {
+		// The patch is centred anywhere in the image and clipped at its edges
+		x0 := rng.Intn(w) - c.Cutout/2
+		y0 := rng.Intn(h) - c.Cutout/2
+		for y := max(y0, 0); y < min(y0+c.Cutout, h); y++ {
+			for x := max(x0, 0); x < min(x0+c.Cutout, w); x++ {
+				for ch := 0; ch < shape.Channels; ch++ {
+					pixels[ch*plane+y*w+x] = 0
+				}
+			}
+		}
+	}
+}
+
+// bilinear samples a w x h plane at a fractional position; pixels outside
+// the plane are 0.
+func bilinear(plane []float32, w, h int, x, y float64) float32 {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := float32(x-float64(x0)), float32(y-float64(y0))
+	at := func(x, y int) float32 {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return 0
+		}
+		return plane[y*w+x]
+	}
+	top := at(x0, y0)*(1-fx) + at(x0+1, y0)*fx
+	bottom := at(x0, y0+1)*(1-fx) + at(x0+1, y0+1)*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// elasticField draws a random displacement per pixel, smooths it with a
+// gaussian of the given sigma and scales it so its largest value is alpha.
+func elasticField(w, h int, alpha, sigma float64, rng *rand.Rand) []float64 {
+	field := make([]float64, w*h)
+	for i := range field {
+		field[i] = 2*rng.Float64() - 1
+	}
+
+	// Separable gaussian blur, rows then columns
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+	}
+	blurred := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, weight float64
+			for k, kv := range kernel {
+				if j := x + k - radius; j >= 0 && j < w {
+					sum += kv * field[y*w+j]
+					weight += kv
+				}
+			}
+			blurred[y*w+x] = sum / weight
+		}
+	}
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			var sum, weight float64
+			for k, kv := range kernel {
+				if j := y + k - radius; j >= 0 && j < h {
+					sum += kv * blurred[j*w+x]
+					weight += kv
+				}
+			}
+			field[y*w+x] = sum / weight
+		}
+	}
+
+	var peak float64
+	for _, v := range field {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak > 0 {
+		for i := range field {
+			field[i] *= alpha / peak
+		}
+	}
+	return field
+}
+
+// augmentedSessions builds the sessions of the samples of src at indices,
+// perturbing each image with aug. Samples that are not stored as image rows
+// are passed through unchanged.
+func augmentedSessions(src SessionSource, indices []int, aug AugmentConfig, rng *rand.Rand) []blueprint.Session {
+	sessions := make([]blueprint.Session, len(indices))
+	for i, index := range indices {
+		data, row := denseSample(src, index)
+		if data == nil || data.Image == nil {
+			sessions[i] = src.Session(index)
+			continue
+		}
+		pixels := append([]float32(nil), data.InputRow(row)...)
+		aug.Augment(pixels, *data.Image, rng)
+		sessions[i] = data.sessionFromRow(pixels, data.OutputRow(row))
+	}
+	return sessions
+}
+
+// denseSample finds the dense dataset and row sample i of src is stored in.
+func denseSample(src SessionSource, i int) (*DenseDataset, int) {
+	switch s := src.(type) {
+	case *DenseDataset:
+		return s, i
+	case *SessionSubset:
+		return denseSample(s.Source, s.Indices[i])
+	}
+	return nil, 0
+}
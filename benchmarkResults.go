@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchmarkResult is the machine-readable outcome of one benchmark run.
+type BenchmarkResult struct {
+	Timestamp       time.Time            `json:"timestamp"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Environment     BenchmarkEnvironment `json:"environment"`
+	// Raw holds the values exactly as RunBenchmark formatted them, keyed by
+	// label. Metrics is nil when they could not be parsed as numbers.
+	Raw     map[string]string `json:"raw"`
+	Metrics *BenchmarkMetrics `json:"metrics,omitempty"`
+}
+
+// BenchmarkEnvironment describes the machine and build a benchmark ran on.
+type BenchmarkEnvironment struct {
+	GoVersion  string `json:"go_version"`
+	GOOS       string `json:"goos"`
+	GOARCH     string `json:"goarch"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	NumCPU     int    `json:"num_cpu"`
+	CPUModel   string `json:"cpu_model,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+}
+
+// BenchmarkMetrics holds the numbers reported by bp.RunBenchmark. Higher is
+// better for all of them.
+type BenchmarkMetrics struct {
+	Float32SingleOps    float64 `json:"float32_single_ops_per_sec"`
+	Float64SingleOps    float64 `json:"float64_single_ops_per_sec"`
+	Float32MultiOps     float64 `json:"float32_multi_ops_per_sec"`
+	Float64MultiOps     float64 `json:"float64_multi_ops_per_sec"`
+	Float32SingleLayers float64 `json:"float32_single_max_layers"`
+	Float64SingleLayers float64 `json:"float64_single_max_layers"`
+	Float32MultiLayers  float64 `json:"float32_multi_max_layers"`
+	Float64MultiLayers  float64 `json:"float64_multi_max_layers"`
+}
+
+// benchmarkMetric is one named value of BenchmarkMetrics.
+type benchmarkMetric struct {
+	Label string
+	Value float64
+}
+
+// benchmarkLabels names the values of RunBenchmark in the order it returns them.
+var benchmarkLabels = [8]string{
+	"Float32 Single-threaded Ops/sec",
+	"Float64 Single-threaded Ops/sec",
+	"Float32 Multi-threaded Ops/sec",
+	"Float64 Multi-threaded Ops/sec",
+	"Max Float32 Single-threaded Layers",
+	"Max Float64 Single-threaded Layers",
+	"Max Float32 Multi-threaded Layers",
+	"Max Float64 Multi-threaded Layers",
+}
+
+// list returns the metrics in the order RunBenchmark reports them.
+func (m BenchmarkMetrics) list() []benchmarkMetric {
+	values := [8]float64{
+		m.Float32SingleOps, m.Float64SingleOps, m.Float32MultiOps, m.Float64MultiOps,
+		m.Float32SingleLayers, m.Float64SingleLayers, m.Float32MultiLayers, m.Float64MultiLayers,
+	}
+	metrics := make([]benchmarkMetric, len(values))
+	for i, v := range values {
+		metrics[i] = benchmarkMetric{benchmarkLabels[i], v}
+	}
+	return metrics
+}
+
+// parseBenchmarkMetrics converts the eight formatted strings returned by
+// bp.RunBenchmark back into numbers. RunBenchmark does not document its
+// format, so callers must cope with an error here.
+func parseBenchmarkMetrics(formatted [8]string) (BenchmarkMetrics, error) {
+	var values [8]float64
+	for i, s := range formatted {
+		v, err := parseFormattedNumber(s)
+		if err != nil {
+			return BenchmarkMetrics{}, err
+		}
+		values[i] = v
+	}
+	return BenchmarkMetrics{
+		Float32SingleOps:    values[0],
+		Float64SingleOps:    values[1],
+		Float32MultiOps:     values[2],
+		Float64MultiOps:     values[3],
+		Float32SingleLayers: values[4],
+		Float64SingleLayers: values[5],
+		Float32MultiLayers:  values[6],
+		Float64MultiLayers:  values[7],
+	}, nil
+}
+
+// parseFormattedNumber parses numbers like "1,234,567", "12.5M" or "3.2 B".
+func parseFormattedNumber(s string) (float64, error) {
+	str := strings.TrimSpace(strings.ReplaceAll(s, ",", ""))
+	multiplier := 1.0
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'K', 'k':
+			multiplier = 1e3
+		case 'M', 'm':
+			multiplier = 1e6
+		case 'B', 'b', 'G', 'g':
+			multiplier = 1e9
+		case 'T', 't':
+			multiplier = 1e12
+		}
+		if multiplier != 1 {
+			str = strings.TrimSpace(str[:n-1])
+		}
+	}
+
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse benchmark value %q", s)
+	}
+	return v * multiplier, nil
+}
+
+// currentBenchmarkEnvironment describes the running process and machine.
+func currentBenchmarkEnvironment() BenchmarkEnvironment {
+	return BenchmarkEnvironment{
+		GoVersion:  runtime.Version(),
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		CPUModel:   cpuModel(),
+		Commit:     buildCommit(),
+	}
+}
+
+// cpuModel returns the CPU model name, or "" if it cannot be determined.
+func cpuModel() string {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/proc/cpuinfo")
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), ":")
+			if ok && strings.TrimSpace(key) == "model name" {
+				return strings.TrimSpace(value)
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("sysctl", "-n", "machdep.cpu.brand_string").Output()
+		if err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return ""
+}
+
+// buildCommit returns the VCS revision the binary was built from, falling back
+// to the HEAD of the git checkout in the working directory.
+func buildCommit() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Print writes the results in the human-readable format of TestRunBenchmark.
+func (r *BenchmarkResult) Print(w io.Writer) {
+	fmt.Fprintln(w, "\nBenchmark Results:")
+	for _, label := range benchmarkLabels {
+		fmt.Fprintf(w, "%s: %s\n", label, r.Raw[label])
+	}
+	env := r.Environment
+	fmt.Fprintf(w, "Environment: %s %s/%s, GOMAXPROCS=%d, CPU %q, commit %s\n",
+		env.GoVersion, env.GOOS, env.GOARCH, env.GOMAXPROCS, env.CPUModel, env.Commit)
+}
+
+// WriteJSON writes the results as indented JSON.
+func (r *BenchmarkResult) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode benchmark result: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// SaveBenchmarkResult writes the result to path atomically.
+func SaveBenchmarkResult(r *BenchmarkResult, path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode benchmark result: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write benchmark result: %w", err)
+	}
+	return nil
+}
+
+// LoadBenchmarkResult reads a result written by SaveBenchmarkResult.
+func LoadBenchmarkResult(path string) (*BenchmarkResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read benchmark result: %w", err)
+	}
+	var r BenchmarkResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode benchmark result %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// historyPath returns the file in dir that stores a result taken at t.
+func historyPath(dir string, t time.Time) string {
+	return filepath.Join(dir, "bench-"+t.UTC().Format("20060102T150405.000Z")+".json")
+}
+
+// CompareBenchmarks prints how current compares with baseline and returns an
+// error if any metric dropped by more than tolerance (a fraction, e.g. 0.05).
+func CompareBenchmarks(w io.Writer, baseline, current *BenchmarkResult, tolerance float64) error {
+	if baseline.Metrics == nil || current.Metrics == nil {
+		return fmt.Errorf("cannot compare benchmark results whose values could not be parsed")
+	}
+	fmt.Fprintf(w, "\nComparison with baseline from %s (tolerance %.1f%%):\n",
+		baseline.Timestamp.Format(time.RFC3339), 100*tolerance)
+
+	base := baseline.Metrics.list()
+	var regressions []string
+	for i, m := range current.Metrics.list() {
+		old := base[i].Value
+		status := "ok"
+		change := 0.0
+		if old > 0 {
+			change = (m.Value - old) / old
+			if change < -tolerance {
+				status = "REGRESSION"
+				regressions = append(regressions, m.Label)
+			}
+		}
+		fmt.Fprintf(w, "  %-36s %16.0f -> %16.0f  %+7.2f%%  %s\n", m.Label, old, m.Value, 100*change, status)
+	}
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("benchmark regression beyond %.1f%% in: %s", 100*tolerance, strings.Join(regressions, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseFormattedNumber(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "42", want: 42},
+		{in: "1,234,567", want: 1234567},
+		{in: " 3.5 ", want: 3.5},
+		{in: "12.5M", want: 12.5e6},
+		{in: "3.2 B", want: 3.2e9},
+		{in: "7k", want: 7000},
+		{in: "2G", want: 2e9},
+		{in: "1.5T", want: 1.5e12},
+		{in: "", wantErr: true},
+		{in: "M", wantErr: true},
+		{in: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFormattedNumber(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFormattedNumber(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !approxEqual(got, tt.want) {
+			t.Errorf("parseFormattedNumber(%q) = %g, want %g", tt.in, got, tt.want)
+		}
+	}
+}
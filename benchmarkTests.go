@@ -2,32 +2,40 @@ package main
 
 import (
 	"blueprint"
-	"fmt"
+	"log"
 	"time"
 )
 
-// TestRunBenchmark runs a benchmark for the AI framework and prints the results.
-func TestRunBenchmark() {
+// TestRunBenchmark runs a benchmark for the AI framework for the given duration and returns the results.
+func TestRunBenchmark(benchmarkDuration time.Duration) *BenchmarkResult {
 	// Initialize a Blueprint instance
 	bp := blueprint.NewBlueprint()
 
-	// Set the benchmark duration
-	benchmarkDuration := 10 * time.Second // Adjust as needed
-
-	fmt.Println("Starting benchmark for the AI framework...")
+	log.Println("Starting benchmark for the AI framework...")
+	started := time.Now()
 	formattedOps32Single, formattedOps64Single, formattedOps32Multi, formattedOps64Multi, maxLayers32Single, maxLayers64Single, maxLayers32Multi, maxLayers64Multi := bp.RunBenchmark(benchmarkDuration)
 
-	// Print the benchmark results
-	fmt.Println("\nBenchmark Results:")
-	fmt.Printf("Float32 Single-threaded Ops/sec: %s\n", formattedOps32Single)
-	fmt.Printf("Float64 Single-threaded Ops/sec: %s\n", formattedOps64Single)
-	fmt.Printf("Float32 Multi-threaded Ops/sec: %s\n", formattedOps32Multi)
-	fmt.Printf("Float64 Multi-threaded Ops/sec: %s\n", formattedOps64Multi)
-	fmt.Printf("Max Float32 Single-threaded Layers: %s\n", maxLayers32Single)
-	fmt.Printf("Max Float64 Single-threaded Layers: %s\n", maxLayers64Single)
-	fmt.Printf("Max Float32 Multi-threaded Layers: %s\n", maxLayers32Multi)
-	fmt.Printf("Max Float64 Multi-threaded Layers: %s\n", maxLayers64Multi)
-	fmt.Println("\nBenchmark complete.")
+	formatted := [8]string{
+		formattedOps32Single, formattedOps64Single, formattedOps32Multi, formattedOps64Multi,
+		maxLayers32Single, maxLayers64Single, maxLayers32Multi, maxLayers64Multi,
+	}
+	log.Println("Benchmark complete.")
+
+	result := &BenchmarkResult{
+		Timestamp:       started,
+		DurationSeconds: benchmarkDuration.Seconds(),
+		Environment:     currentBenchmarkEnvironment(),
+		Raw:             make(map[string]string, len(formatted)),
+	}
+	for i, s := range formatted {
+		result.Raw[benchmarkLabels[i]] = s
+	}
+	if metrics, err := parseBenchmarkMetrics(formatted); err != nil {
+		log.Printf("Keeping only the raw benchmark output: %v", err)
+	} else {
+		result.Metrics = &metrics
+	}
+	return result
 }
 
 func TestGpuRunBenchmark() {
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"blueprint"
+)
+
+// Pipeline stages recorded in checkpoints, in the order they run.
+const (
+	stageSearch         = "search"
+	stagePostProcessing = "post_processing"
+	stageEvaluation     = "evaluation"
+	stageDone           = "done"
+)
+
+// Checkpoint is the resumable state of a training pipeline.
+type Checkpoint struct {
+	Experiment Experiment    `json:"experiment"`
+	Metadata   ModelMetadata `json:"metadata"`
+
+	Stage    string         `json:"stage"`
+	Search   SearchProgress `json:"search"`
+	NextStep int            `json:"next_step"` // index of the next post-processing step
+
+	// RNGSeed is the seed math/rand was reset to when the checkpoint was
+	// written; the generator's internal state cannot be saved, so the pipeline
+	// reseeds at every checkpoint and a resumed run continues the same stream.
+	RNGSeed        int64           `json:"rng_seed"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	Blueprint      json.RawMessage `json:"blueprint,omitempty"`
+}
+
+// SearchProgress tracks the search stage across rounds.
+type SearchProgress struct {
+	Iteration   int             `json:"iteration"` // search iterations completed
+	Round       int             `json:"round"`
+	StaleRounds int             `json:"stale_rounds"` // rounds since the best score last improved
+	BestScore   float64         `json:"best_score"`
+	Best        json.RawMessage `json:"best_blueprint,omitempty"`
+	Weights     []float64       `json:"sampler_weights,omitempty"` // learnt by a feedback sampler
+}
+
+// TrainingRun drives an experiment through the pipeline stages and writes a
+// checkpoint to Experiment.Checkpoint after every round and step.
+type TrainingRun struct {
+	Checkpoint
+
+	started time.Time // when this process started or resumed the run
+	elapsed float64   // seconds spent before started
+	pending bool      // whether Blueprint still has to be restored into the network
+}
+
+// NewTrainingRun starts a pipeline for the experiment at the search stage.
+func NewTrainingRun(exp Experiment, meta ModelMetadata) *TrainingRun {
+	meta.Experiment = exp.Name
+	return &TrainingRun{
+		Checkpoint: Checkpoint{
+			Experiment: exp,
+			Metadata:   meta,
+			Stage:      stageSearch,
+		},
+		started: time.Now(),
+	}
+}
+
+// ResumeTrainingRun loads a checkpoint written by an earlier run.
+func ResumeTrainingRun(path string) (*TrainingRun, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	run := &TrainingRun{started: time.Now(), pending: true}
+	if err := json.Unmarshal(data, &run.Checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %w", path, err)
+	}
+	if err := run.Experiment.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid experiment in checkpoint %s: %w", path, err)
+	}
+	run.elapsed = run.ElapsedSeconds
+
+	log.Printf("Resuming experiment %s from %s: stage %s, iteration %d, step %d, %s elapsed",
+		run.Experiment.Name, path, run.Stage, run.Search.Iteration, run.NextStep,
+		time.Duration(run.elapsed*float64(time.Second)).Round(time.Second))
+	return run, nil
+}
+
+// RestoreInto loads the checkpointed network into bp and reseeds math/rand.
+// It does nothing for a fresh run or once the network has been restored.
+func (r *TrainingRun) RestoreInto(bp *blueprint.Blueprint) error {
+	if !r.pending {
+		return nil
+	}
+	r.pending = false
+
+	if len(r.Blueprint) > 0 {
+		if err := RestoreBlueprint(bp, string(r.Blueprint)); err != nil {
+			return err
+		}
+	}
+	rand.Seed(r.RNGSeed)
+	return nil
+}
+
+// Save records the network's current state and writes the checkpoint if the
+// experiment names a checkpoint file. math/rand is reseeded either way so that
+// runs with and without checkpoints draw the same numbers.
+func (r *TrainingRun) Save(bp *blueprint.Blueprint) error {
+	r.RNGSeed = r.boundarySeed()
+	rand.Seed(r.RNGSeed)
+
+	if err := r.snapshot(bp); err != nil {
+		return err
+	}
+
+	path := r.Experiment.Checkpoint
+	if path == "" {
+		return nil
+	}
+
+	r.ElapsedSeconds = r.elapsed + time.Since(r.started).Seconds()
+
+	data, err := json.Marshal(r.Checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// snapshot records the network's current state in the checkpoint.
+func (r *TrainingRun) snapshot(bp *blueprint.Blueprint) error {
+	jsonStr, err := bp.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
+	}
+	r.Blueprint = json.RawMessage(jsonStr)
+	return nil
+}
+
+// SaveBest writes the best network seen so far to the experiment's output
+// file: during the search, the best validation snapshot if there is one,
+// otherwise, and in later stages, the network as of the last checkpoint, so
+// finished post-processing steps are kept. It is used when the run is
+// interrupted and the live network may be mid-update.
+func (r *TrainingRun) SaveBest() error {
+	best := r.Search.Best
+	if best == nil || r.Stage != stageSearch {
+		best = r.Blueprint
+	}
+	if best == nil {
+		log.Println("No snapshot of the network has been taken yet, nothing to save.")
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.Experiment.Output), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := saveModelSnapshot(string(best), r.Experiment.Output, r.Metadata); err != nil {
+		return fmt.Errorf("failed to save model: %w", err)
+	}
+	log.Printf("Saved the best model so far to %s", r.Experiment.Output)
+	return nil
+}
+
+// Advance moves the pipeline to the given stage and checkpoints it.
+func (r *TrainingRun) Advance(bp *blueprint.Blueprint, stage string) error {
+	r.Stage = stage
+	return r.Save(bp)
+}
+
+// boundarySeed derives the seed for the current pipeline position from the run seed.
+func (r *TrainingRun) boundarySeed() int64 {
+	position := int64(r.Search.Iteration)<<20 | int64(r.Search.Round)<<8 | int64(r.NextStep)
+	switch r.Stage {
+	case stagePostProcessing:
+		position += 1 << 40
+	case stageEvaluation, stageDone:
+		position += 2 << 40
+	}
+	return r.Metadata.Seed ^ position*0x5DEECE66D
+}
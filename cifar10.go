@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CIFAR-10 binary format: every record is one label byte followed by the
+// red, green and blue planes of a 32x32 image, each stored row by row.
+const (
+	cifarSide       = 32
+	cifarPlane      = cifarSide * cifarSide
+	cifarChannels   = 3
+	cifarRecordSize = 1 + cifarChannels*cifarPlane
+
+	// cifarBatchDir is the directory the binary archive extracts to.
+	cifarBatchDir = "cifar-10-batches-bin"
+)
+
+// cifarTrainBatches and cifarTestBatches name the batch files of each split.
+var (
+	cifarTrainBatches = []string{"data_batch_1.bin", "data_batch_2.bin", "data_batch_3.bin", "data_batch_4.bin", "data_batch_5.bin"}
+	cifarTestBatches  = []string{"test_batch.bin"}
+)
+
+// cifarInputID returns the input neuron for a pixel channel. Colour images
+// use the layout of the binary files: neuron 1 + c*1024 + y*32 + x for
+// channel c (0 red, 1 green, 2 blue), so IDs 1-1024 are the red plane,
+// 1025-2048 green and 2049-3072 blue. Grayscale images only use the first
+// plane, IDs 1-1024.
+func cifarInputID(channel, y, x int) int {
+	return 1 + channel*cifarPlane + y*cifarSide + x
+}
+
+// LoadCIFAR10 reads CIFAR-10 binary batch files into a dense dataset with
+// inputs scaled to [0, 1] and one-hot expected outputs on ds.OutputNodes().
+// With grayscale set, each pixel is converted to its luminance
+// (0.299 R + 0.587 G + 0.114 B) and only 1024 inputs are used.
+func LoadCIFAR10(ds ImageDataset, batchFiles []string, grayscale bool) (*DenseDataset, error) {
+	channels := cifarChannels
+	if grayscale {
+		channels = 1
+	}
+	inputNodes := make([]int, 0, channels*cifarPlane)
+	for c := 0; c < channels; c++ {
+		for y := 0; y < cifarSide; y++ {
+			for x := 0; x < cifarSide; x++ {
+				inputNodes = append(inputNodes, cifarInputID(c, y, x))
+			}
+		}
+	}
+	outputNodes := ds.OutputNodes()
+
+	var data *DenseDataset
+	inputs := make([]float32, len(inputNodes))
+	outputs := make([]float32, len(outputNodes))
+	for _, file := range batchFiles {
+		records, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CIFAR-10 batch: %w", err)
+		}
+		if len(records)%cifarRecordSize != 0 {
+			return nil, fmt.Errorf("%s is not a CIFAR-10 binary batch: size %d is not a multiple of %d", file, len(records), cifarRecordSize)
+		}
+
+		count := len(records) / cifarRecordSize
+		log.Printf("Loading %d images (32x32 RGB) from %s...", count, filepath.Base(file))
+		if data == nil {
+			data = NewDenseDataset(inputNodes, outputNodes, count*len(batchFiles))
+		}
+
+		for i := 0; i < count; i++ {
+			record := records[i*cifarRecordSize : (i+1)*cifarRecordSize]
+			label := int(record[0])
+			if label >= len(outputNodes) {
+				return nil, fmt.Errorf("label %d out of range in %s", label, file)
+			}
+			pixels := record[1:]
+
+			if grayscale {
+				for p := 0; p < cifarPlane; p++ {
+					r, g, b := float32(pixels[p]), float32(pixels[cifarPlane+p]), float32(pixels[2*cifarPlane+p])
+					inputs[p] = (0.299*r + 0.587*g + 0.114*b) / 255.0
+				}
+			} else {
+				for p, v := range pixels {
+					inputs[p] = float32(v) / 255.0 // Normalize [0, 1]
+				}
+			}
+
+			// One-hot expected output
+			for c := range outputs {
+				outputs[c] = 0
+			}
+			outputs[label] = 1
+			data.Append(inputs, outputs)
+		}
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no CIFAR-10 batch files given")
+	}
+	data.Image = &ImageShape{Width: cifarSide, Height: cifarSide, Channels: channels}
+	return data, nil
+}
+
+// UnpackCIFAR10 writes the images of the batch files as colour PNG files plus
+// a labels.json map. It is only needed to inspect the data.
+func UnpackCIFAR10(batchFiles []string, outputDir string) error {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	labelMap := make(map[string]int)
+	n := 0
+	for _, file := range batchFiles {
+		records, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read CIFAR-10 batch: %w", err)
+		}
+
+		for i := 0; i+cifarRecordSize <= len(records); i += cifarRecordSize {
+			record := records[i : i+cifarRecordSize]
+			pixels := record[1:]
+
+			img := image.NewRGBA(image.Rect(0, 0, cifarSide, cifarSide))
+			for p := 0; p < cifarPlane; p++ {
+				img.Set(p%cifarSide, p/cifarSide, color.RGBA{
+					R: pixels[p],
+					G: pixels[cifarPlane+p],
+					B: pixels[2*cifarPlane+p],
+					A: 255,
+				})
+			}
+
+			imgFilename := fmt.Sprintf("img_%05d.png", n)
+			imgPath := filepath.Join(outputDir, imgFilename)
+			imgOut, err := os.Create(imgPath)
+			if err != nil {
+				return fmt.Errorf("failed to create image file %s: %w", imgPath, err)
+			}
+			if err := png.Encode(imgOut, img); err != nil {
+				imgOut.Close()
+				return fmt.Errorf("failed to encode image %s: %w", imgPath, err)
+			}
+			imgOut.Close()
+
+			labelMap[imgFilename] = int(record[0])
+			n++
+		}
+	}
+
+	data, err := json.MarshalIndent(labelMap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode label map: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "labels.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write label map: %w", err)
+	}
+
+	log.Printf("Unpacked %d CIFAR-10 images.", n)
+	return nil
+}
+
+// extractTarGz extracts the regular files and directories of a .tar.gz
+// archive into targetDir.
+func extractTarGz(archive, targetDir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", archive, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+
+		// Refuse entries that would land outside targetDir
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("archive %s contains unsafe path %q", archive, header.Name)
+		}
+		path := filepath.Join(targetDir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			out, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// syntheticCIFARFetcher writes a small, deterministic archive in the
+// CIFAR-10 binary layout. Each image is noise plus a bar in one colour
+// channel whose channel and position depend on the class.
+type syntheticCIFARFetcher struct {
+	seed int64
+}
+
+func (syntheticCIFARFetcher) synthetic() {}
+
+func (f syntheticCIFARFetcher) Fetch(name, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	zw := gzip.NewWriter(out)
+	tw := tar.NewWriter(zw)
+
+	rng := rand.New(rand.NewSource(f.seed))
+	batches := append(append([]string{}, cifarTrainBatches...), cifarTestBatches...)
+	for _, batch := range batches {
+		count := syntheticTrainCount / len(cifarTrainBatches)
+		if batch == cifarTestBatches[0] {
+			count = syntheticTestCount
+		}
+
+		records := make([]byte, count*cifarRecordSize)
+		for i := 0; i < count; i++ {
+			record := records[i*cifarRecordSize : (i+1)*cifarRecordSize]
+			label := rng.Intn(10)
+			record[0] = byte(label)
+			drawSyntheticCIFAR(record[1:], label, rng)
+		}
+
+		header := &tar.Header{
+			Name:     cifarBatchDir + "/" + batch,
+			Mode:     0644,
+			Size:     int64(len(records)),
+			Typeflag: tar.TypeReg,
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			break
+		}
+		if _, err = tw.Write(records); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// drawSyntheticCIFAR renders the pattern for label into the three colour planes.
+func drawSyntheticCIFAR(pixels []byte, label int, rng *rand.Rand) {
+	for i := range pixels {
+		pixels[i] = byte(rng.Intn(48))
+	}
+
+	channel := label % cifarChannels
+	offset := 2 + (label/cifarChannels)*8
+	for j := 0; j < cifarSide; j++ {
+		for w := 0; w < 3; w++ {
+			pixels[channel*cifarPlane+(offset+w)*cifarSide+j] = byte(200 + rng.Intn(56))
+		}
+	}
+}
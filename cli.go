@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"blueprint"
+)
+
+// Exit codes returned by the hammer command.
+const (
+	exitOK      = 0 // the command completed successfully
+	exitFailure = 1 // the command ran but failed
+	exitUsage   = 2 // the command line could not be understood
+
+	exitInterrupted = 130 // the run was stopped by SIGINT or SIGTERM
+)
+
+// Command is a top-level hammer subcommand.
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(opts *GlobalOptions, args []string) error
+}
+
+// GlobalOptions holds the flags given before the subcommand name.
+type GlobalOptions struct {
+	// Seed seeds math/rand before every scenario run.
+	Seed int64
+}
+
+// commands returns every top-level subcommand in the order shown by help.
+func commands() []Command {
+	return []Command{
+		{
+			Name:        "run",
+			Usage:       "run <scenario> [flags]",
+			Description: "Run a registered scenario",
+			Run:         runCommand,
+		},
+		{
+			Name:        "list",
+			Usage:       "list",
+			Description: "List the registered scenarios and their flags",
+			Run:         listCommand,
+		},
+		{
+			Name:        "predict",
+			Usage:       "predict [flags]",
+			Description: "Run a saved model on new inputs",
+			Run:         predictCommand,
+		},
+		{
+			Name:        "serve",
+			Usage:       "serve [flags]",
+			Description: "Serve saved models over HTTP",
+			Run:         serveCommand,
+		},
+		{
+			Name:        "repl",
+			Usage:       "repl [flags]",
+			Description: "Call Blueprint methods interactively",
+			Run:         replCommand,
+		},
+		{
+			Name:        "rpc",
+			Usage:       "rpc [flags]",
+			Description: "Drive Blueprints over JSON-RPC",
+			Run:         rpcCommand,
+		},
+		{
+			Name:        "schema",
+			Usage:       "schema [flags]",
+			Description: "Generate an OpenAPI document and JSON Schemas of the Blueprint methods",
+			Run:         schemaCommand,
+		},
+		{
+			Name:        "lint",
+			Usage:       "lint <config.json>...",
+			Description: "Check neuron configs before LoadNeurons sees them",
+			Run:         lintCommand,
+		},
+		{
+			Name:        "bench",
+			Usage:       "bench [flags]",
+			Description: "Benchmark the framework",
+			Run:         benchCommand,
+		},
+	}
+}
+
+// usageError reports a malformed command line; it maps to exitUsage.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// usageErrorf formats a usageError.
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// runCLI dispatches the command line to a subcommand and returns the process exit code.
+func runCLI(args []string) int {
+	opts, args, err := parseGlobalOptions(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(os.Stdout)
+		}
+		return exitCode(err)
+	}
+
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "help" {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	for _, cmd := range commands() {
+		if cmd.Name == name {
+			return exitCode(cmd.Run(opts, args[1:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "hammer: unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// parseGlobalOptions parses the flags that precede the subcommand and returns
+// the remaining arguments. Without --seed a seed is derived from the clock.
+func parseGlobalOptions(args []string) (*GlobalOptions, []string, error) {
+	fs := newFlagSet("hammer")
+	fs.Usage = func() {}
+	opts := &GlobalOptions{}
+	fs.Int64Var(&opts.Seed, "seed", 0, "seed for the random number generator (default: derived from the clock)")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, nil, err
+	}
+
+	seeded := false
+	fs.Visit(func(f *flag.Flag) {
+		seeded = seeded || f.Name == "seed"
+	})
+	if !seeded {
+		opts.Seed = time.Now().UnixNano()
+	}
+	return opts, fs.Args(), nil
+}
+
+// exitCode reports err on stderr and converts it to a process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	fmt.Fprintf(os.Stderr, "hammer: %v\n", err)
+	var uerr *usageError
+	if errors.As(err, &uerr) {
+		return exitUsage
+	}
+	if errors.Is(err, errInterrupted) {
+		return exitInterrupted
+	}
+	return exitFailure
+}
+
+// printUsage writes the top-level help text.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hammer [--seed N] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	fmt.Fprintln(w, "  --seed N                 seed the random number generator so runs can be reproduced")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-24s %s\n", cmd.Usage, cmd.Description)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"hammer list\" to see the available scenarios.")
+}
+
+// newFlagSet creates a flag set whose parse errors are returned rather than fatal.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseFlags parses args into fs, turning parse failures into usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// runCommand implements "hammer run <scenario> [flags]".
+func runCommand(opts *GlobalOptions, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return usageErrorf("usage: hammer run <scenario> [flags] (see \"hammer list\")")
+	}
+
+	scenario, ok := findScenario(args[0])
+	if !ok {
+		return usageErrorf("unknown scenario %q (see \"hammer list\")", args[0])
+	}
+
+	fs := newFlagSet("run " + scenario.Name)
+	checkRepro := fs.Bool("check-repro", false, "run the scenario twice with the same seed and compare the resulting networks")
+	run := scenario.Setup(fs)
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	ctx := context.Background()
+	if scenario.Interruptible {
+		var stop context.CancelFunc
+		ctx, stop = newSignalContext("saving the best model and stopping")
+		defer stop()
+	}
+
+	if *checkRepro {
+		return checkReproducibility(ctx, scenario.Name, opts.Seed, run)
+	}
+
+	fmt.Printf("---%s---\n", scenario.Name)
+	_, err := runScenario(ctx, scenario.Name, opts.Seed, run)
+	return err
+}
+
+// listCommand implements "hammer list".
+func listCommand(opts *GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("list takes no arguments")
+	}
+	listScenarios()
+	return nil
+}
+
+// benchCommand implements "hammer bench".
+func benchCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("bench")
+	duration := fs.Duration("duration", 10*time.Second, "how long each benchmark runs")
+	gpu := fs.Bool("gpu", false, "run the GPU benchmark instead of the CPU one")
+	jsonOut := fs.Bool("json", false, "print the results as JSON")
+	historyDir := fs.String("history", "benchmarks/history", "directory that keeps every result (empty to disable)")
+	baseline := fs.String("baseline", "", "compare against this result file and fail on a regression")
+	tolerance := fs.Float64("tolerance", 0.05, "fraction a metric may drop below the baseline before it counts as a regression")
+	saveBaseline := fs.String("save-baseline", "", "also write the result to this file for later comparisons")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tolerance < 0 {
+		return usageErrorf("--tolerance must not be negative")
+	}
+
+	if *gpu {
+		// The GPU benchmark only prints its results
+		var unsupported []string
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "json", "history", "baseline", "tolerance", "save-baseline":
+				unsupported = append(unsupported, "--"+f.Name)
+			}
+		})
+		if len(unsupported) > 0 {
+			return usageErrorf("--gpu cannot be combined with %s", strings.Join(unsupported, ", "))
+		}
+		TestGpuRunBenchmark()
+		return nil
+	}
+
+	// Load the baseline first so a bad path fails before the benchmark runs
+	var base *BenchmarkResult
+	if *baseline != "" {
+		var err error
+		if base, err = LoadBenchmarkResult(*baseline); err != nil {
+			return err
+		}
+		if base.Metrics == nil {
+			return fmt.Errorf("baseline %s has no parsed metrics to compare against", *baseline)
+		}
+	}
+
+	result := TestRunBenchmark(*duration)
+
+	// Keep human-readable reports off stdout when it carries JSON
+	report := io.Writer(os.Stdout)
+	if *jsonOut {
+		report = os.Stderr
+		if err := result.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		result.Print(os.Stdout)
+	}
+
+	if *historyDir != "" {
+		path := historyPath(*historyDir, result.Timestamp)
+		if err := SaveBenchmarkResult(result, path); err != nil {
+			return err
+		}
+		log.Printf("Saved benchmark result to %s", path)
+	}
+	if *saveBaseline != "" {
+		if err := SaveBenchmarkResult(result, *saveBaseline); err != nil {
+			return err
+		}
+		log.Printf("Saved baseline to %s", *saveBaseline)
+	}
+
+	if base != nil {
+		return CompareBenchmarks(report, base, result, *tolerance)
+	}
+	return nil
+}
+
+// predictCommand implements "hammer predict".
+func predictCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("predict")
+	modelPath := fs.String("model", "", "model JSON file to load (required)")
+	inputPath := fs.String("input", "", "PNG/JPEG image, CSV/TSV or JSON file, or a directory of them (required)")
+	timesteps := fs.Int("timesteps", 0, "timesteps per input (default: as trained)")
+	jsonOut := fs.Bool("json", false, "print one JSON object per prediction")
+	topK := fs.Int("top", defaultTopK, "most likely classes shown per prediction")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *modelPath == "" || *inputPath == "" {
+		return usageErrorf("--model and --input are required")
+	}
+	if *topK < 1 {
+		return usageErrorf("--top must be at least 1")
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	model, err := LoadModelJSON(*modelPath)
+	if err != nil {
+		return err
+	}
+	inputs, err := readPredictionInputs(*inputPath, model)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, in := range inputs {
+		prediction := model.Predict(in, *timesteps)
+		if *jsonOut {
+			if err := encoder.Encode(prediction); err != nil {
+				return err
+			}
+			continue
+		}
+		prediction.Print(os.Stdout, *topK)
+	}
+	return nil
+}
+
+// serveCommand implements "hammer serve".
+func serveCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("serve")
+	models := modelFlags{}
+	fs.Var(models, "model", "model to serve as name=path, or a path served under its file name (repeatable)")
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on (use :8080 to accept other hosts)")
+	poolSize := fs.Int("pool", runtime.GOMAXPROCS(0), "clones of each model, i.e. requests a model handles at once")
+	reload := fs.Duration("reload-interval", 2*time.Second, "how often model files are checked for changes (0 disables hot-reload)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(models) == 0 {
+		return usageErrorf("at least one --model is required")
+	}
+	if *poolSize <= 0 {
+		return usageErrorf("--pool must be positive")
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	server, err := NewModelServer(models, *poolSize)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := newSignalContext("shutting down")
+	defer stop()
+	return serveModels(ctx, *addr, server, *reload)
+}
+
+// rpcCommand implements "hammer rpc".
+func rpcCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("rpc")
+	// Loopback by default: Blueprint methods read and write files
+	addr := fs.String("addr", "127.0.0.1:8090", "address to listen on")
+	ttl := fs.Duration("session-ttl", 30*time.Minute, "how long an idle session is kept (0 keeps sessions until closed)")
+	token := fs.String("token", os.Getenv(rpcTokenEnv), "bearer token clients must send (default: $"+rpcTokenEnv+", or a random one printed at startup)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *ttl < 0 {
+		return usageErrorf("--session-ttl must not be negative")
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+	if *token == "" {
+		var err error
+		if *token, err = newRPCToken(); err != nil {
+			return err
+		}
+		log.Printf("Clients must send the header \"Authorization: Bearer %s\".", *token)
+	}
+
+	server, err := NewRPCServer(*token)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := newSignalContext("shutting down")
+	defer stop()
+	return serveRPC(ctx, *addr, server, *ttl)
+}
+
+// replCommand implements "hammer repl".
+func replCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("repl")
+	modelPath := fs.String("model", "", "saved model to start from (default: an empty network)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	bp := blueprint.NewBlueprint()
+	if *modelPath != "" {
+		model, err := LoadModelJSON(*modelPath)
+		if err != nil {
+			return err
+		}
+		bp = model.Blueprint
+	}
+	repl, err := NewRepl(bp, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return repl.Run(os.Stdin)
+}
+
+// schemaCommand implements "hammer schema".
+func schemaCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("schema")
+	out := fs.String("out", "", "file to write the OpenAPI document to (default: standard output)")
+	schemaDir := fs.String("schema-dir", "", "directory to also write standalone JSON Schemas to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	infos, err := blueprint.NewBlueprint().GetBlueprintMethods()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve blueprint methods: %w", err)
+	}
+
+	data, err := json.MarshalIndent(GenerateOpenAPI(infos), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the OpenAPI document: %w", err)
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*out, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	} else {
+		log.Printf("Wrote %s", *out)
+	}
+
+	if *schemaDir != "" {
+		written, err := WriteJSONSchemas(*schemaDir, infos)
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote %d JSON Schemas to %s", len(written), *schemaDir)
+	}
+	return nil
+}
+
+// lintCommand implements "hammer lint <config.json>...". Problems are printed
+// as file:line:column: message; any problem makes the command fail.
+func lintCommand(opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("lint")
+	allowUnknown := fs.Bool("allow-unknown", false, "report unknown neuron types, activations and update rules as warnings")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("usage: hammer lint <config.json>...")
+	}
+
+	total := 0
+	for _, path := range fs.Args() {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, p := range LintNeuronConfig(data, *allowUnknown) {
+			fmt.Printf("%s:%s\n", path, p)
+			if !p.Warning {
+				total++
+			}
+		}
+	}
+	switch total {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("found 1 problem")
+	}
+	return fmt.Errorf("found %d problems", total)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"blueprint"
+)
+
+// SessionSource is an indexed set of samples whose sessions may be built on demand.
+type SessionSource interface {
+	Len() int
+	Session(i int) blueprint.Session
+}
+
+// SessionList adapts a slice of sessions to SessionSource.
+type SessionList []blueprint.Session
+
+// Len returns the number of sessions.
+func (l SessionList) Len() int { return len(l) }
+
+// Session returns the i-th session.
+func (l SessionList) Session(i int) blueprint.Session { return l[i] }
+
+// DenseDataset stores samples as contiguous float32 rows instead of one pair
+// of maps per sample; column j of a row feeds the neuron InputIDs[j] (or is
+// expected from OutputIDs[j]). Sessions are built only when asked for.
+type DenseDataset struct {
+	InputIDs  []int
+	OutputIDs []int
+	Timesteps int
+	// Image, when set, describes the input rows as image planes.
+	Image *ImageShape
+
+	n       int       // number of samples
+	inputs  []float32 // n rows of len(InputIDs) values
+	outputs []float32 // n rows of len(OutputIDs) values
+}
+
+// NewDenseDataset creates an empty dataset with room for capacity samples.
+func NewDenseDataset(inputIDs, outputIDs []int, capacity int) *DenseDataset {
+	return &DenseDataset{
+		InputIDs:  inputIDs,
+		OutputIDs: outputIDs,
+		Timesteps: 1,
+		inputs:    make([]float32, 0, capacity*len(inputIDs)),
+		outputs:   make([]float32, 0, capacity*len(outputIDs)),
+	}
+}
+
+// DenseFromSessions copies sessions into a dense dataset. Inputs or outputs
+// missing from a session are stored as 0; Timesteps is taken from the first
+// session.
+func DenseFromSessions(sessions []blueprint.Session) *DenseDataset {
+	inputIDs, outputIDs := sessionNodeIDs(sessions)
+	d := NewDenseDataset(inputIDs, outputIDs, len(sessions))
+	if len(sessions) > 0 && sessions[0].Timesteps > 0 {
+		d.Timesteps = sessions[0].Timesteps
+	}
+
+	in := make([]float32, len(inputIDs))
+	out := make([]float32, len(outputIDs))
+	for _, s := range sessions {
+		for j, id := range inputIDs {
+			in[j] = float32(s.InputVariables[id])
+		}
+		for j, id := range outputIDs {
+			out[j] = float32(s.ExpectedOutput[id])
+		}
+		d.Append(in, out)
+	}
+	return d
+}
+
+// Append adds one sample. The rows are copied.
+func (d *DenseDataset) Append(inputs, outputs []float32) {
+	if len(inputs) != len(d.InputIDs) || len(outputs) != len(d.OutputIDs) {
+		panic(fmt.Sprintf("dense dataset row has %d inputs and %d outputs, want %d and %d",
+			len(inputs), len(outputs), len(d.InputIDs), len(d.OutputIDs)))
+	}
+	d.inputs = append(d.inputs, inputs...)
+	d.outputs = append(d.outputs, outputs...)
+	d.n++
+}
+
+// Len returns the number of samples.
+func (d *DenseDataset) Len() int { return d.n }
+
+// InputRow returns the inputs of sample i; the slice aliases the dataset.
+func (d *DenseDataset) InputRow(i int) []float32 {
+	n := len(d.InputIDs)
+	return d.inputs[i*n : (i+1)*n]
+}
+
+// OutputRow returns the expected outputs of sample i; the slice aliases the dataset.
+func (d *DenseDataset) OutputRow(i int) []float32 {
+	n := len(d.OutputIDs)
+	return d.outputs[i*n : (i+1)*n]
+}
+
+// Session builds the session for sample i.
+func (d *DenseDataset) Session(i int) blueprint.Session {
+	return d.sessionFromRow(d.InputRow(i), d.OutputRow(i))
+}
+
+// sessionFromRow builds a session from rows laid out like the dataset's.
+func (d *DenseDataset) sessionFromRow(inputs, outputs []float32) blueprint.Session {
+	inputVars := make(map[int]float64, len(d.InputIDs))
+	for j, v := range inputs {
+		inputVars[d.InputIDs[j]] = float64(v)
+	}
+	expectedOutput := make(map[int]float64, len(d.OutputIDs))
+	for j, v := range outputs {
+		expectedOutput[d.OutputIDs[j]] = float64(v)
+	}
+	return blueprint.Session{
+		InputVariables: inputVars,
+		ExpectedOutput: expectedOutput,
+		Timesteps:      d.Timesteps,
+	}
+}
+
+// class returns the index in outputNodes of the largest expected output of
+// sample i, without building its session. Ties go to the lower index.
+func (d *DenseDataset) class(i int, outputNodes []int) int {
+	row := d.OutputRow(i)
+	best := 0
+	bestVal := math.Inf(-1)
+	for class, id := range outputNodes {
+		for j, outID := range d.OutputIDs {
+			if outID == id && float64(row[j]) > bestVal {
+				best = class
+				bestVal = float64(row[j])
+			}
+		}
+	}
+	return best
+}
+
+// SessionSubset is a view of some samples of another source.
+type SessionSubset struct {
+	Source  SessionSource
+	Indices []int
+}
+
+// Len returns the number of samples in the view.
+func (s *SessionSubset) Len() int { return len(s.Indices) }
+
+// Session builds the session for the i-th sample of the view.
+func (s *SessionSubset) Session(i int) blueprint.Session { return s.Source.Session(s.Indices[i]) }
+
+// sessionClass returns the class of sample i of src, using the dense rows
+// directly when possible.
+func sessionClass(src SessionSource, i int, outputNodes []int) int {
+	switch s := src.(type) {
+	case *DenseDataset:
+		return s.class(i, outputNodes)
+	case *SessionSubset:
+		return sessionClass(s.Source, s.Indices[i], outputNodes)
+	}
+	return argmaxClass(src.Session(i).ExpectedOutput, outputNodes)
+}
+
+// sessionBatch builds n sessions of src starting at sample start, wrapping
+// around at the end. Asking for more than Len() sessions returns them all.
+func sessionBatch(src SessionSource, start, n int) []blueprint.Session {
+	total := src.Len()
+	if total == 0 {
+		return nil
+	}
+	if n <= 0 || n > total {
+		n = total
+	}
+
+	batch := make([]blueprint.Session, n)
+	for i := range batch {
+		batch[i] = src.Session((start + i) % total)
+	}
+	return batch
+}
+
+// sessionsAt builds the sessions of the samples of src at indices.
+func sessionsAt(src SessionSource, indices []int) []blueprint.Session {
+	sessions := make([]blueprint.Session, len(indices))
+	for i, index := range indices {
+		sessions[i] = src.Session(index)
+	}
+	return sessions
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"blueprint"
+)
+
+// datasetSourceEnv overrides where dataset archives are fetched from when no
+// --data-source flag is given.
+const datasetSourceEnv = "HAMMER_DATA_SOURCE"
+
+// syntheticSource selects the generated stand-in for a dataset.
+const syntheticSource = "synthetic"
+
+// DatasetFetcher stores a named dataset archive at a local path.
+type DatasetFetcher interface {
+	Fetch(name, dest string) error
+}
+
+// syntheticFetcher is implemented by the fetchers that generate stand-in data.
+type syntheticFetcher interface {
+	synthetic()
+}
+
+// httpFetcher downloads archives relative to a base URL.
+type httpFetcher struct {
+	bp      *blueprint.Blueprint
+	baseURL string
+}
+
+func (f httpFetcher) Fetch(name, dest string) error {
+	return f.bp.DownloadFile(dest, f.baseURL+name)
+}
+
+// dirFetcher copies archives from a local mirror directory.
+type dirFetcher struct {
+	dir string
+}
+
+func (f dirFetcher) Fetch(name, dest string) error {
+	src, err := os.Open(filepath.Join(f.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to open mirrored file: %w", err)
+	}
+	defer src.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", name, err)
+	}
+	return out.Close()
+}
+
+// resolveDatasetSource picks the fetcher for a source given on the command
+// line, falling back to $HAMMER_DATA_SOURCE and then to defaultURL. A source
+// may be an http(s) base URL, a file:// URL or plain directory holding the
+// archives, or "synthetic", which selects the given generated stand-in.
+// verify reports whether fetched archives must match the published checksums.
+func resolveDatasetSource(bp *blueprint.Blueprint, source, defaultURL string, synthetic DatasetFetcher) (fetcher DatasetFetcher, verify bool, err error) {
+	if source == "" {
+		source = os.Getenv(datasetSourceEnv)
+	}
+	if source == "" {
+		source = defaultURL
+	}
+
+	switch {
+	case source == "":
+		return nil, false, fmt.Errorf("the dataset has no public mirror, pass --data-source with a directory holding its archives")
+	case source == syntheticSource:
+		return synthetic, false, nil
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		if !strings.HasSuffix(source, "/") {
+			source += "/"
+		}
+		return httpFetcher{bp: bp, baseURL: source}, true, nil
+	case strings.HasPrefix(source, "file://"):
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid dataset source %q: %w", source, err)
+		}
+		return dirFetcher{dir: u.Path}, true, nil
+	case strings.Contains(source, "://"):
+		return nil, false, fmt.Errorf("unsupported dataset source %q", source)
+	default:
+		return dirFetcher{dir: source}, true, nil
+	}
+}
+
+// verifyChecksum checks the file at path against a digest written as
+// "md5:<hex>" or "sha256:<hex>"; a bare hex digest is taken to be SHA-256.
+func verifyChecksum(path, want string) error {
+	algorithm, digest, ok := strings.Cut(want, ":")
+	if !ok {
+		algorithm, digest = "sha256", want
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported checksum algorithm %q for %s", algorithm, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("checksum mismatch for %s: expected %s %s, got %s", path, algorithm, digest, got)
+	}
+	return nil
+}
+
+// Sizes and seed of the synthetic image dataset stand-in.
+const (
+	syntheticMNISTSeed  = 1
+	syntheticTrainCount = 600
+	syntheticTestCount  = 100
+	syntheticImageSize  = 28
+)
+
+// syntheticMNISTFetcher writes small, deterministic IDX archives in the
+// layout of an image dataset so that the pipeline can run without network
+// access. Each image is a bright bar whose position depends on the class,
+// plus noise.
+type syntheticMNISTFetcher struct {
+	seed    int64
+	dataset ImageDataset
+}
+
+func (syntheticMNISTFetcher) synthetic() {}
+
+func (f syntheticMNISTFetcher) Fetch(name, dest string) error {
+	ds := f.dataset
+	test := name == ds.TestImages || name == ds.TestLabels
+	count := syntheticTrainCount
+	if test {
+		count = syntheticTestCount
+	}
+
+	// Derive the stream from the split so images and labels stay in step
+	seed := f.seed
+	if test {
+		seed++
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	labels := make([]byte, count)
+	images := make([]byte, count*syntheticImageSize*syntheticImageSize)
+	for i := range labels {
+		class := rng.Intn(ds.Classes())
+		labels[i] = byte(class + ds.LabelOffset)
+		drawSyntheticDigit(images[i*syntheticImageSize*syntheticImageSize:(i+1)*syntheticImageSize*syntheticImageSize], class, rng)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	zw := gzip.NewWriter(out)
+
+	if name == ds.TrainLabels || name == ds.TestLabels {
+		err = writeIDX(zw, idxLabelMagic, []uint32{uint32(count)}, labels)
+	} else {
+		err = writeIDX(zw, idxImageMagic, []uint32{uint32(count), syntheticImageSize, syntheticImageSize}, images)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// drawSyntheticDigit renders the pattern for label into pixels.
+func drawSyntheticDigit(pixels []byte, label int, rng *rand.Rand) {
+	for i := range pixels {
+		pixels[i] = byte(rng.Intn(32))
+	}
+
+	// Even labels are horizontal bars, odd labels vertical, at one of five
+	// offsets; datasets with more than ten classes reuse the patterns
+	offset := 4 + (label/2%5)*4
+	for j := 0; j < syntheticImageSize; j++ {
+		for w := 0; w < 2; w++ {
+			row, col := offset+w, j
+			if label%2 == 1 {
+				row, col = j, offset+w
+			}
+			pixels[row*syntheticImageSize+col] = byte(200 + rng.Intn(56))
+		}
+	}
+}
+
+// writeIDX writes an unsigned-byte IDX file with the given dimensions.
+func writeIDX(w io.Writer, magic uint32, dims []uint32, data []byte) error {
+	header := append([]uint32{magic}, dims...)
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
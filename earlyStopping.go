@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+
+	"blueprint"
+)
+
+// EarlyStoppingOptions controls how NAS is split into rounds and when it stops.
+type EarlyStoppingOptions struct {
+	ValidationFraction float64 `json:"validation_fraction"` // share of the sessions held out for validation
+	RoundIterations    int     `json:"round_iterations"`    // NAS iterations between two validation passes
+	Patience           int     `json:"patience"`            // rounds without improvement before stopping
+	MinDelta           float64 `json:"min_delta"`           // smallest accuracy gain that counts as improvement
+}
+
+// DefaultEarlyStoppingOptions returns the settings used when no flags are
+// given. No sessions are held out, so early stopping is off until a
+// validation fraction is set.
+func DefaultEarlyStoppingOptions() EarlyStoppingOptions {
+	return EarlyStoppingOptions{
+		ValidationFraction: 0,
+		RoundIterations:    1000,
+		Patience:           3,
+		MinDelta:           0.001,
+	}
+}
+
+// Validate checks the settings.
+func (o EarlyStoppingOptions) Validate() error {
+	if o.ValidationFraction < 0 || o.ValidationFraction >= 1 {
+		return fmt.Errorf("validation_fraction must be in [0, 1)")
+	}
+	if o.Patience < 1 {
+		return fmt.Errorf("patience must be at least 1")
+	}
+	if o.RoundIterations < 0 {
+		return fmt.Errorf("round_iterations must not be negative")
+	}
+	if o.MinDelta < 0 {
+		return fmt.Errorf("min_delta must not be negative")
+	}
+	return nil
+}
+
+// stratifiedSplit shuffles the samples and holds out fraction of every class
+// for validation, so both parts keep the label distribution of the input.
+func stratifiedSplit(sessions SessionSource, outputNodes []int, fraction float64, rng *rand.Rand) (train, validation *SessionSubset) {
+	byClass := make([][]int, len(outputNodes))
+	for i := 0; i < sessions.Len(); i++ {
+		class := sessionClass(sessions, i, outputNodes)
+		byClass[class] = append(byClass[class], i)
+	}
+
+	train = &SessionSubset{Source: sessions}
+	validation = &SessionSubset{Source: sessions}
+	for _, group := range byClass {
+		rng.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+
+		n := int(float64(len(group))*fraction + 0.5)
+		validation.Indices = append(validation.Indices, group[:n]...)
+		train.Indices = append(train.Indices, group[n:]...)
+	}
+
+	// Interleave the classes again
+	rng.Shuffle(len(train.Indices), func(i, j int) {
+		train.Indices[i], train.Indices[j] = train.Indices[j], train.Indices[i]
+	})
+	return train, validation
+}
+
+// runWithEarlyStopping calls nasRound until maxIterations iterations have run
+// in total, in rounds of opts.RoundIterations. After every round the network
+// is scored on the validation sessions and afterRound is called; the search
+// stops once accuracy has not improved for opts.Patience rounds, and the
+// best-scoring network is restored. Progress is kept in progress so that an
+// interrupted search can continue where it left off.
+func runWithEarlyStopping(bp *blueprint.Blueprint, validation SessionSource, outputNodes []int, maxIterations int, opts EarlyStoppingOptions, progress *SearchProgress, nasRound func(iterations int) error, afterRound func() error) error {
+	if progress.Best == nil {
+		best, err := bp.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to snapshot blueprint: %w", err)
+		}
+		progress.Best = json.RawMessage(best)
+		progress.BestScore = EvaluateClassifier(bp, validation, outputNodes).Accuracy()
+		log.Printf("Initial validation accuracy: %.2f%%", 100*progress.BestScore)
+	}
+
+	for progress.Iteration < maxIterations && progress.StaleRounds < opts.Patience {
+		iterations := opts.RoundIterations
+		if iterations <= 0 || iterations > maxIterations-progress.Iteration {
+			iterations = maxIterations - progress.Iteration
+		}
+		if err := nasRound(iterations); err != nil {
+			return err
+		}
+		progress.Iteration += iterations
+		progress.Round++
+
+		accuracy := EvaluateClassifier(bp, validation, outputNodes).Accuracy()
+		log.Printf("Round %d (%d/%d iterations): validation accuracy %.2f%% (best %.2f%%)",
+			progress.Round, progress.Iteration, maxIterations, 100*accuracy, 100*progress.BestScore)
+
+		if accuracy > progress.BestScore+opts.MinDelta {
+			best, err := bp.ToJSON()
+			if err != nil {
+				return fmt.Errorf("failed to snapshot blueprint: %w", err)
+			}
+			progress.Best = json.RawMessage(best)
+			progress.BestScore = accuracy
+			progress.StaleRounds = 0
+		} else {
+			progress.StaleRounds++
+		}
+
+		if err := afterRound(); err != nil {
+			return err
+		}
+	}
+
+	if progress.StaleRounds >= opts.Patience {
+		log.Printf("Validation accuracy has not improved for %d rounds, stopped early.", progress.StaleRounds)
+	}
+
+	if err := RestoreBlueprint(bp, string(progress.Best)); err != nil {
+		return err
+	}
+	log.Printf("Restored the best validation snapshot (%.2f%%).", 100*progress.BestScore)
+	return nil
+}
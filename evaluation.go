@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"blueprint"
+)
+
+// defaultTopK is the k reported as top-k accuracy by EvaluateClassifier.
+const defaultTopK = 3
+
+// ClassificationReport summarises how a network classifies a set of labelled sessions.
+type ClassificationReport struct {
+	Total       int
+	Correct     int
+	TopK        int              // k used for TopKCorrect
+	TopKCorrect int              // sessions whose class was among the k highest outputs
+	Confusion   [][]int          // Confusion[expected][predicted] counts
+	LossSum     float64          // summed cross-entropy of the softmax outputs
+	Calibration []CalibrationBin // predictions binned by softmax confidence
+
+	// ClassNames, when set, labels the classes in Print.
+	ClassNames []string
+}
+
+// NewClassificationReport returns an empty report for the given number of classes.
+func NewClassificationReport(classes, topK int) *ClassificationReport {
+	r := &ClassificationReport{
+		TopK:        topK,
+		Confusion:   make([][]int, classes),
+		Calibration: make([]CalibrationBin, calibrationBins),
+	}
+	for i := range r.Confusion {
+		r.Confusion[i] = make([]int, classes)
+	}
+	return r
+}
+
+// Add records one prediction given the network's raw per-class outputs and
+// the expected class.
+func (r *ClassificationReport) Add(logits []float64, expected int) {
+	probs := Softmax(logits)
+	predicted := Argmax(logits)
+
+	r.Total++
+	r.Confusion[expected][predicted]++
+	if expected == predicted {
+		r.Correct++
+	}
+	for _, class := range TopK(logits, r.TopK) {
+		if class == expected {
+			r.TopKCorrect++
+			break
+		}
+	}
+	r.LossSum += CrossEntropy(logits, expected)
+
+	bin := &r.Calibration[calibrationBin(probs[predicted])]
+	bin.Count++
+	bin.ConfidenceSum += probs[predicted]
+	if expected == predicted {
+		bin.Correct++
+	}
+}
+
+// EvaluateClassifier runs every session through the network and tallies the
+// predictions. outputNodes maps class index i to the output neuron outputNodes[i].
+// Sessions are built one at a time.
+func EvaluateClassifier(bp *blueprint.Blueprint, sessions SessionSource, outputNodes []int) *ClassificationReport {
+	report := NewClassificationReport(len(outputNodes), defaultTopK)
+	for i := 0; i < sessions.Len(); i++ {
+		session := sessions.Session(i)
+		expected := argmaxClass(session.ExpectedOutput, outputNodes)
+
+		bp.RunNetwork(session.InputVariables, session.Timesteps)
+		report.Add(classLogits(bp.GetOutputs(), outputNodes), expected)
+	}
+	return report
+}
+
+// Accuracy returns the fraction of sessions classified correctly.
+func (r *ClassificationReport) Accuracy() float64 {
+	return ratio(r.Correct, r.Total)
+}
+
+// TopKAccuracy returns the fraction of sessions whose class was among the
+// TopK highest outputs.
+func (r *ClassificationReport) TopKAccuracy() float64 {
+	return ratio(r.TopKCorrect, r.Total)
+}
+
+// MeanCrossEntropy returns the average cross-entropy loss per session.
+func (r *ClassificationReport) MeanCrossEntropy() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return r.LossSum / float64(r.Total)
+}
+
+// ECE returns the expected calibration error of the softmax confidences.
+func (r *ClassificationReport) ECE() float64 {
+	return expectedCalibrationError(r.Calibration)
+}
+
+// Precision returns the fraction of predictions of the class that were right.
+func (r *ClassificationReport) Precision(class int) float64 {
+	predicted := 0
+	for _, row := range r.Confusion {
+		predicted += row[class]
+	}
+	return ratio(r.Confusion[class][class], predicted)
+}
+
+// Recall returns the fraction of sessions of the class classified correctly.
+func (r *ClassificationReport) Recall(class int) float64 {
+	total := 0
+	for _, n := range r.Confusion[class] {
+		total += n
+	}
+	return ratio(r.Confusion[class][class], total)
+}
+
+// F1 returns the harmonic mean of the class's precision and recall.
+func (r *ClassificationReport) F1(class int) float64 {
+	p, rec := r.Precision(class), r.Recall(class)
+	if p+rec == 0 {
+		return 0
+	}
+	return 2 * p * rec / (p + rec)
+}
+
+// MacroF1 returns the unweighted mean F1 score over all classes.
+func (r *ClassificationReport) MacroF1() float64 {
+	if len(r.Confusion) == 0 {
+		return 0
+	}
+	var sum float64
+	for class := range r.Confusion {
+		sum += r.F1(class)
+	}
+	return sum / float64(len(r.Confusion))
+}
+
+// className returns the name of the class, or its index if it has none.
+func (r *ClassificationReport) className(class int) string {
+	if class < len(r.ClassNames) {
+		return r.ClassNames[class]
+	}
+	return fmt.Sprint(class)
+}
+
+// ratio returns n/d, or 0 when d is 0.
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// Print writes the accuracy figures and confusion matrix in a readable form.
+func (r *ClassificationReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Top-1 accuracy: %.2f%% (%d/%d)\n", 100*r.Accuracy(), r.Correct, r.Total)
+	fmt.Fprintf(w, "Top-%d accuracy: %.2f%% (%d/%d)\n", r.TopK, 100*r.TopKAccuracy(), r.TopKCorrect, r.Total)
+	if loss := r.MeanCrossEntropy(); math.IsInf(loss, 1) {
+		fmt.Fprintln(w, "Cross-entropy: +Inf (a class had no output)")
+	} else {
+		fmt.Fprintf(w, "Cross-entropy: %.4f\n", loss)
+	}
+	fmt.Fprintf(w, "Expected calibration error: %.4f\n", r.ECE())
+	fmt.Fprintf(w, "Macro F1: %.4f\n", r.MacroF1())
+
+	fmt.Fprintln(w, "\nPer-class metrics:")
+	fmt.Fprintln(w, "  class  precision   recall      F1  name")
+	for class := range r.Confusion {
+		fmt.Fprintf(w, "  %5d  %8.2f%%  %6.2f%%  %6.4f  %s\n", class, 100*r.Precision(class), 100*r.Recall(class), r.F1(class), r.className(class))
+	}
+
+	fmt.Fprintln(w, "\nConfusion matrix (rows: expected, columns: predicted):")
+	fmt.Fprint(w, "    ")
+	for class := range r.Confusion {
+		fmt.Fprintf(w, " %6d", class)
+	}
+	fmt.Fprintln(w)
+	for expected, row := range r.Confusion {
+		fmt.Fprintf(w, "  %2d", expected)
+		for _, n := range row {
+			fmt.Fprintf(w, " %6d", n)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// RegressionReport compares a network's squared error with that of always
+// predicting the mean expected outputs of the training sessions, the error a
+// network that learned nothing about its inputs would reach.
+type RegressionReport struct {
+	Total       int
+	MSE         float64
+	BaselineMSE float64
+
+	outputNodes   []int
+	means         []float64 // the baseline's prediction of each output
+	sum, baseline float64   // summed squared errors
+}
+
+// NewRegressionReport returns an empty report over outputNodes whose
+// baseline predicts means, as returned by meanOutputs.
+func NewRegressionReport(outputNodes []int, means []float64) *RegressionReport {
+	return &RegressionReport{outputNodes: outputNodes, means: means}
+}
+
+// meanOutputs returns the mean of each output over the expected outputs.
+func meanOutputs(expected []map[int]float64, outputNodes []int) []float64 {
+	means := make([]float64, len(outputNodes))
+	for _, e := range expected {
+		for k, id := range outputNodes {
+			means[k] += e[id] / float64(len(expected))
+		}
+	}
+	return means
+}
+
+// Add records the network's outputs for one session.
+func (r *RegressionReport) Add(outputs, expected map[int]float64) {
+	for k, id := range r.outputNodes {
+		r.sum += (outputs[id] - expected[id]) * (outputs[id] - expected[id])
+		r.baseline += (r.means[k] - expected[id]) * (r.means[k] - expected[id])
+	}
+	r.Total++
+	if n := float64(r.Total * len(r.outputNodes)); n > 0 {
+		r.MSE = r.sum / n
+		r.BaselineMSE = r.baseline / n
+	}
+}
+
+// EvaluateRegression runs every session through the network and measures the
+// mean squared error over outputNodes against the baseline fitted on train.
+func EvaluateRegression(bp *blueprint.Blueprint, sessions, train SessionSource, outputNodes []int) *RegressionReport {
+	expected := make([]map[int]float64, train.Len())
+	for i := range expected {
+		expected[i] = train.Session(i).ExpectedOutput
+	}
+	report := NewRegressionReport(outputNodes, meanOutputs(expected, outputNodes))
+	for i := 0; i < sessions.Len(); i++ {
+		session := sessions.Session(i)
+		bp.RunNetwork(session.InputVariables, session.Timesteps)
+		report.Add(bp.GetOutputs(), session.ExpectedOutput)
+	}
+	return report
+}
+
+// Print writes the error figures in a readable form.
+func (r *RegressionReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Mean squared error: %.6f over %d sessions\n", r.MSE, r.Total)
+	fmt.Fprintf(w, "Predicting the training mean: %.6f\n", r.BaselineMSE)
+	if r.BaselineMSE > 0 {
+		fmt.Fprintf(w, "Explained variance: %.2f%%\n", 100*(1-r.MSE/r.BaselineMSE))
+	}
+}
@@ -0,0 +1,756 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"blueprint"
+)
+
+// Search strategies an experiment can select; each names the Blueprint method
+// it runs, except SequenceHillClimbing, which hammer runs itself so that
+// sequence windows are fed one step at a time (see searchSequence).
+const (
+	strategySimpleNAS                 = "SimpleNAS"
+	strategySimpleNASWithoutCrossover = "SimpleNASWithoutCrossover"
+	strategyRandomConnections         = "SimpleNASWithRandomConnections"
+	strategyAdvancedParallelNAS       = "AdvancedParallelNASWithDynamicNeuronGeneration"
+	strategySequenceHillClimbing      = "SequenceHillClimbing"
+)
+
+// Post-processing steps an experiment can run after the search.
+const (
+	stepTargetedMicroRefinement = "TargetedMicroRefinement"
+	stepTryAddConnections       = "TryAddConnections"
+)
+
+// Experiment is a declarative description of a NAS run, loaded from a JSON
+// experiment file or built from one of the defaults below.
+type Experiment struct {
+	Name           string            `json:"name"`
+	Dataset        DatasetConfig     `json:"dataset"`
+	Search         SearchConfig      `json:"search"`
+	PostProcessing []PostProcessStep `json:"post_processing,omitempty"`
+
+	// LogDir, when set, receives a PerformanceLogger report after the search.
+	// An interrupted run leaves whatever the logger has written so far; it
+	// is not flushed.
+	LogDir string `json:"log_dir,omitempty"`
+	// Output is the file the final model is written to.
+	Output string `json:"output"`
+
+	// Checkpoint, when set, is rewritten after every search round and
+	// post-processing step so that the run can be resumed with --resume.
+	Checkpoint string `json:"checkpoint,omitempty"`
+	// CheckpointEvery is the number of search iterations between checkpoints
+	// when the search is not already split into validation rounds.
+	CheckpointEvery int `json:"checkpoint_every,omitempty"`
+}
+
+// DatasetConfig selects the sessions an experiment trains on.
+type DatasetConfig struct {
+	// Name is a registered image dataset such as "mnist" or
+	// "fashion-mnist", "linear-sum", "inline", "tabular" or "sequence".
+	Name string `json:"name"`
+	// Source overrides where archives are fetched from (see resolveDatasetSource).
+	Source string `json:"source,omitempty"`
+	// ExportPNG also writes the training images out as PNG files.
+	ExportPNG bool `json:"export_png,omitempty"`
+	// Grayscale converts colour images to one luminance input per pixel.
+	Grayscale bool `json:"grayscale,omitempty"`
+	// Augment perturbs the training images of each search round.
+	Augment *AugmentConfig `json:"augment,omitempty"`
+	// Preprocess lists the preprocessing steps fitted on the training inputs;
+	// the fitted pipeline is saved with the model.
+	Preprocess []PreprocessStep `json:"preprocess,omitempty"`
+	// Sessions holds the data of an "inline" dataset.
+	Sessions []InlineSession `json:"sessions,omitempty"`
+	// Tabular describes the CSV or TSV file of a "tabular" dataset.
+	Tabular *TabularConfig `json:"tabular,omitempty"`
+	// Sequence describes the task of a "sequence" dataset.
+	Sequence *SequenceConfig `json:"sequence,omitempty"`
+}
+
+// InlineSession is a training sample written directly in an experiment file.
+type InlineSession struct {
+	Inputs    map[int]float64 `json:"inputs"`
+	Expected  map[int]float64 `json:"expected"`
+	Timesteps int             `json:"timesteps,omitempty"`
+}
+
+// SearchConfig selects a search strategy and its hyperparameters. Fields that
+// a strategy does not take are ignored.
+type SearchConfig struct {
+	Strategy                   string   `json:"strategy"`
+	MaxIterations              int      `json:"max_iterations"`
+	NeuronTypes                []string `json:"neuron_types,omitempty"`
+	ForgivenessThreshold       float64  `json:"forgiveness_threshold,omitempty"`
+	Metrics                    []string `json:"metrics,omitempty"`
+	WeightUpdateIterations     int      `json:"weight_update_iterations,omitempty"`
+	UseHillClimbing            bool     `json:"use_hill_climbing,omitempty"`
+	SaveImprovedModel          bool     `json:"save_improved_model,omitempty"`
+	SaveLocation               string   `json:"save_location,omitempty"`
+	MaxTriesWithoutImprovement int      `json:"max_tries_without_improvement,omitempty"`
+	Batches                    int      `json:"batches,omitempty"`
+
+	// SessionBatchSize, when positive, trains each search round and
+	// post-processing step on the next batch of this many sessions instead
+	// of the whole training set, so only one batch is held in memory.
+	SessionBatchSize int `json:"session_batch_size,omitempty"`
+	// Sampler chooses the sessions of each batch: "sequential" (default),
+	// "shuffled", "stratified", "balanced" or "hard" (see sampler.go).
+	Sampler string `json:"sampler,omitempty"`
+
+	// EarlyStopping splits classification runs into validated rounds.
+	EarlyStopping *EarlyStoppingOptions `json:"early_stopping,omitempty"`
+}
+
+// PostProcessStep is one refinement applied to the network after the search.
+type PostProcessStep struct {
+	Step string `json:"step"`
+
+	// TargetedMicroRefinement
+	MaxIterations             int     `json:"max_iterations,omitempty"`
+	SampleSubsetSize          int     `json:"sample_subset_size,omitempty"`
+	ConnectionTrialsPerSample int     `json:"connection_trials_per_sample,omitempty"`
+	ImprovementThreshold      float64 `json:"improvement_threshold,omitempty"`
+
+	// TryAddConnections
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Validate checks the step name and the parameters the step takes.
+func (s PostProcessStep) Validate() error {
+	switch s.Step {
+	case stepTargetedMicroRefinement:
+		if s.MaxIterations <= 0 || s.SampleSubsetSize <= 0 || s.ConnectionTrialsPerSample <= 0 {
+			return fmt.Errorf("%s: max_iterations, sample_subset_size and connection_trials_per_sample must be positive", s.Step)
+		}
+		if s.ImprovementThreshold < 0 {
+			return fmt.Errorf("%s: improvement_threshold must not be negative", s.Step)
+		}
+	case stepTryAddConnections:
+		if s.Attempts <= 0 {
+			return fmt.Errorf("%s: attempts must be positive", s.Step)
+		}
+	default:
+		return fmt.Errorf("unknown step %q", s.Step)
+	}
+	return nil
+}
+
+// DefaultMNISTExperiment returns the settings the mnist scenario has always used.
+func DefaultMNISTExperiment() Experiment {
+	return DefaultImageExperiment(imageDatasets["mnist"])
+}
+
+// DefaultImageExperiment returns the mnist settings adapted to another image
+// dataset; files are kept in the dataset's directory.
+func DefaultImageExperiment(ds ImageDataset) Experiment {
+	return Experiment{
+		Name:    ds.Name,
+		Dataset: DatasetConfig{Name: ds.Name},
+		Search: SearchConfig{
+			Strategy:                   strategyAdvancedParallelNAS,
+			MaxIterations:              10000,
+			NeuronTypes:                []string{"dense", "rnn", "cnn", "dropout", "attention"},
+			WeightUpdateIterations:     10,
+			UseHillClimbing:            true,
+			SaveImprovedModel:          true,
+			SaveLocation:               filepath.Join(ds.Dir, modelDir),
+			MaxTriesWithoutImprovement: 10,
+			Batches:                    10,
+		},
+		PostProcessing: []PostProcessStep{
+			{
+				Step:                      stepTargetedMicroRefinement,
+				MaxIterations:             50,
+				SampleSubsetSize:          20,
+				ConnectionTrialsPerSample: 10,
+				ImprovementThreshold:      30.0,
+			},
+		},
+		LogDir:          filepath.Join(ds.Dir, "log"),
+		Output:          filepath.Join(ds.Dir, modelDir, ds.Name+"_model.json"),
+		Checkpoint:      filepath.Join(ds.Dir, "checkpoint.json"),
+		CheckpointEvery: 1000,
+	}
+}
+
+// DefaultRandomConnectionsExperiment returns the settings of the
+// random-connections NAS scenario.
+func DefaultRandomConnectionsExperiment() Experiment {
+	return Experiment{
+		Name:    "random-connections",
+		Dataset: DatasetConfig{Name: "linear-sum"},
+		Search: SearchConfig{
+			Strategy:             strategyRandomConnections,
+			MaxIterations:        100000,
+			ForgivenessThreshold: 0.05, // 5%
+			NeuronTypes: []string{
+				"dense",
+				"rnn",
+				"cnn",
+				"dropout",
+				"batch_norm",
+				"attention",
+				"nca",
+			},
+			WeightUpdateIterations: 10, // Number of weight update steps per NAS iteration
+		},
+		Output: "output/nastest.json",
+	}
+}
+
+// DefaultSequenceExperiment returns the settings of the sequence scenario
+// for a synthetic task. Recurrent neuron types are offered alongside dense
+// ones so runs with and without them can be compared.
+func DefaultSequenceExperiment(task string) Experiment {
+	return Experiment{
+		Name: "sequence-" + task,
+		Dataset: DatasetConfig{
+			Name:     "sequence",
+			Sequence: &SequenceConfig{Task: task},
+		},
+		Search: SearchConfig{
+			Strategy:      strategySequenceHillClimbing,
+			MaxIterations: 2000,
+			NeuronTypes:   []string{"dense", "rnn", "lstm"},
+		},
+		Output: filepath.Join("output", "sequence_"+task+"_model.json"),
+	}
+}
+
+// LoadExperiment reads and validates a JSON experiment file.
+func LoadExperiment(path string) (Experiment, error) {
+	var exp Experiment
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return exp, fmt.Errorf("YAML experiment files are not supported, convert %s to JSON", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return exp, fmt.Errorf("failed to read experiment file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&exp); err != nil {
+		return exp, fmt.Errorf("failed to decode experiment file %s: %w", path, err)
+	}
+
+	if err := exp.Validate(); err != nil {
+		return exp, fmt.Errorf("invalid experiment file %s: %w", path, err)
+	}
+	return exp, nil
+}
+
+// Validate checks that the experiment names a known dataset, strategy and steps.
+func (e Experiment) Validate() error {
+	switch e.Dataset.Name {
+	case "linear-sum":
+	case "inline":
+		if len(e.Dataset.Sessions) == 0 {
+			return fmt.Errorf("inline dataset has no sessions")
+		}
+	case "tabular":
+		if e.Dataset.Tabular == nil {
+			return fmt.Errorf("tabular dataset needs a tabular section")
+		}
+		if err := e.Dataset.Tabular.Validate(); err != nil {
+			return err
+		}
+	case "sequence":
+		if e.Dataset.Sequence == nil {
+			return fmt.Errorf("sequence dataset needs a sequence section")
+		}
+		if err := e.Dataset.Sequence.Validate(); err != nil {
+			return err
+		}
+	default:
+		if _, ok := imageDatasets[e.Dataset.Name]; !ok {
+			return fmt.Errorf("unknown dataset %q", e.Dataset.Name)
+		}
+	}
+	if e.Dataset.Augment != nil {
+		if _, ok := imageDatasets[e.Dataset.Name]; !ok {
+			return fmt.Errorf("augmentation only applies to image datasets")
+		}
+		if err := e.Dataset.Augment.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, step := range e.Dataset.Preprocess {
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("preprocess[%d]: %w", i, err)
+		}
+	}
+	// Augmentation perturbs the preprocessed rows, clamping them to [0, 1]
+	// and padding with 0, which is only right for raw pixels
+	if e.Dataset.Augment != nil && e.Dataset.Augment.Enabled() && len(e.Dataset.Preprocess) > 0 {
+		return fmt.Errorf("augmentation needs raw pixel inputs and cannot be combined with preprocessing")
+	}
+
+	switch e.Search.Strategy {
+	case strategySimpleNAS, strategySimpleNASWithoutCrossover, strategyRandomConnections, strategyAdvancedParallelNAS:
+		if e.Dataset.Name == "sequence" {
+			return fmt.Errorf("sequence datasets are fed one step at a time, which only the %s strategy does", strategySequenceHillClimbing)
+		}
+	case strategySequenceHillClimbing:
+		if e.Dataset.Name != "sequence" {
+			return fmt.Errorf("the %s strategy needs a sequence dataset", strategySequenceHillClimbing)
+		}
+		// The rest of the pipeline runs sessions, not windows
+		if len(e.Dataset.Preprocess) > 0 || len(e.PostProcessing) > 0 || e.Search.EarlyStopping != nil ||
+			e.Search.SessionBatchSize > 0 || e.Search.Sampler != "" || e.LogDir != "" {
+			return fmt.Errorf("the %s strategy does not support preprocess, post_processing, early_stopping, session_batch_size, sampler or log_dir", strategySequenceHillClimbing)
+		}
+	default:
+		return fmt.Errorf("unknown search strategy %q", e.Search.Strategy)
+	}
+	if e.Search.MaxIterations <= 0 {
+		return fmt.Errorf("search.max_iterations must be positive")
+	}
+	if e.Search.SessionBatchSize < 0 {
+		return fmt.Errorf("search.session_batch_size must not be negative")
+	}
+	switch e.Search.Sampler {
+	case "", samplerSequential, samplerShuffled, samplerStratified, samplerBalanced, samplerHard:
+	default:
+		return fmt.Errorf("unknown sampler %q", e.Search.Sampler)
+	}
+	if stopping := e.Search.EarlyStopping; stopping != nil {
+		if err := stopping.Validate(); err != nil {
+			return fmt.Errorf("search.early_stopping: %w", err)
+		}
+	}
+
+	for i, step := range e.PostProcessing {
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("post_processing[%d]: %w", i, err)
+		}
+	}
+
+	if e.Output == "" {
+		return fmt.Errorf("output is required")
+	}
+	return nil
+}
+
+// runExperiment starts a fresh training run of the experiment.
+func runExperiment(rc *RunContext, exp Experiment) error {
+	return runTraining(rc, NewTrainingRun(exp, rc.Metadata()))
+}
+
+// resumeExperiment continues the training run saved in a checkpoint file.
+func resumeExperiment(rc *RunContext, checkpointPath string) error {
+	run, err := ResumeTrainingRun(checkpointPath)
+	if err != nil {
+		return err
+	}
+	if run.Experiment.Checkpoint == "" {
+		run.Experiment.Checkpoint = checkpointPath
+	}
+	rc.Seed = run.Metadata.Seed
+	return runTraining(rc, run)
+}
+
+// runTraining loads the experiment's dataset, trains a network on it and
+// reports how well it does.
+func runTraining(rc *RunContext, run *TrainingRun) error {
+	exp := run.Experiment
+	log.Printf("Running experiment %s", exp.Name)
+
+	if ds, ok := imageDatasets[exp.Dataset.Name]; ok {
+		return runImageDataset(rc, run, ds)
+	}
+	if exp.Dataset.Name == "sequence" {
+		return runSequenceExperiment(rc, run)
+	}
+
+	var sessions, test []blueprint.Session
+	var classOutputs []int
+	var classNames []string
+	switch exp.Dataset.Name {
+	case "linear-sum":
+		sessions = linearSumSessions()
+	case "inline":
+		for _, s := range exp.Dataset.Sessions {
+			timesteps := s.Timesteps
+			if timesteps == 0 {
+				timesteps = 1
+			}
+			sessions = append(sessions, blueprint.Session{
+				InputVariables: s.Inputs,
+				ExpectedOutput: s.Expected,
+				Timesteps:      timesteps,
+			})
+		}
+	case "tabular":
+		data, err := LoadTabular(*exp.Dataset.Tabular)
+		if err != nil {
+			return err
+		}
+		sessions = data.Sessions
+		classOutputs = data.ClassOutputs
+		run.Metadata.Tabular = data.Encoding
+		if classOutputs != nil {
+			classNames = data.OutputNames
+		}
+	}
+
+	if len(exp.Dataset.Preprocess) > 0 {
+		train, err := preprocessTraining(run, DenseFromSessions(sessions))
+		if err != nil {
+			return err
+		}
+		sessions = sessionBatch(train, 0, train.Len())
+		if test != nil {
+			held, err := preprocessHeldOut(run, DenseFromSessions(test))
+			if err != nil {
+				return err
+			}
+			test = sessionBatch(held, 0, held.Len())
+		}
+	}
+
+	run.Metadata.ClassNames = classNames
+	bp := rc.NewBlueprint()
+	inputNodes, outputNodes := sessionNodeIDs(sessions)
+	setupIONeurons(bp, inputNodes, outputNodes)
+
+	if err := TrainWithExperiment(rc.Context, bp, SessionList(sessions), classOutputs, run); err != nil {
+		return err
+	}
+
+	// Test the final model on the first few sessions
+	fmt.Printf("Testing the final model of experiment %s:\n", exp.Name)
+	for _, session := range sessionBatch(SessionList(sessions), 0, 10) {
+		bp.RunNetwork(session.InputVariables, session.Timesteps)
+		predictedOutput := bp.GetOutputs()
+		fmt.Printf("Input: %v, Expected Output: %v, Predicted Output: %v\n",
+			session.InputVariables, session.ExpectedOutput, predictedOutput)
+	}
+	if len(sessions) > 10 {
+		fmt.Printf("(showing 10 of %d sessions)\n", len(sessions))
+	}
+
+	// Evaluate on the held-out sessions, if the dataset has any
+	evaluation := sessions
+	if test != nil {
+		evaluation = test
+		fmt.Printf("\nEvaluating on %d held-out sessions...\n", len(test))
+	} else if classOutputs != nil {
+		fmt.Printf("\nEvaluating on all %d sessions...\n", len(sessions))
+	}
+	if classOutputs != nil {
+		report := EvaluateClassifier(bp, SessionList(evaluation), classOutputs)
+		report.ClassNames = classNames
+		report.Print(os.Stdout)
+	} else if test != nil {
+		EvaluateRegression(bp, SessionList(test), SessionList(sessions), outputNodes).Print(os.Stdout)
+	}
+	return run.Advance(bp, stageDone)
+}
+
+// sessionNodeIDs collects the input and output neuron IDs used by the sessions.
+func sessionNodeIDs(sessions []blueprint.Session) (inputNodes, outputNodes []int) {
+	seenIn := make(map[int]bool)
+	seenOut := make(map[int]bool)
+	for _, s := range sessions {
+		for id := range s.InputVariables {
+			seenIn[id] = true
+		}
+		for id := range s.ExpectedOutput {
+			seenOut[id] = true
+		}
+	}
+	return sortedKeys(seenIn), sortedKeys(seenOut)
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// setupIONeurons registers the input and output nodes and creates their neurons;
+// outputs start out linear and unconnected.
+func setupIONeurons(bp *blueprint.Blueprint, inputNodes, outputNodes []int) {
+	bp.AddInputNodes(inputNodes)
+	bp.AddOutputNodes(outputNodes)
+
+	// Initialize input neurons
+	for _, id := range inputNodes {
+		bp.Neurons[id] = &blueprint.Neuron{
+			ID:   id,
+			Type: "input",
+		}
+	}
+
+	// Initialize output neurons as linear
+	for _, outID := range outputNodes {
+		bp.Neurons[outID] = &blueprint.Neuron{
+			ID:          outID,
+			Type:        "output",
+			Activation:  "linear",
+			Connections: [][]float64{},
+		}
+	}
+}
+
+// TrainWithExperiment runs the experiment's search and post-processing on the
+// sessions and saves the model, checkpointing along the way and skipping the
+// stages a resumed run has already completed. When classOutputs is non-nil
+// the sessions are treated as a classification task over those output
+// neurons, which enables a validation split with early stopping. Sessions are
+// built from the source as the search needs them, a batch of
+// SessionBatchSize per round when that is set.
+//
+// If ctx is cancelled the running stage is abandoned, the best network seen so
+// far is written to the experiment's output file and an error wrapping
+// errInterrupted is returned. bp must not be used after that.
+func TrainWithExperiment(ctx context.Context, bp *blueprint.Blueprint, sessions SessionSource, classOutputs []int, run *TrainingRun) error {
+	// Record what inference needs to feed the saved model
+	meta := &run.Metadata
+	meta.InputNodes, meta.OutputNodes = bp.InputNodes, bp.OutputNodes
+	meta.ClassOutputs = classOutputs
+	if sessions.Len() > 0 {
+		meta.Timesteps = sessions.Session(0).Timesteps
+	}
+
+	var logger *blueprint.PerformanceLogger
+	if run.Experiment.LogDir != "" {
+		// Initialize PerformanceLogger
+		var err error
+		logger, err = blueprint.NewPerformanceLogger(run.Experiment.LogDir)
+		if err != nil {
+			return fmt.Errorf("failed to initialize PerformanceLogger: %w", err)
+		}
+	}
+
+	err := trainStages(ctx, bp, sessions, classOutputs, run, logger)
+	if errors.Is(err, errInterrupted) {
+		if saveErr := run.SaveBest(); saveErr != nil {
+			log.Printf("Failed to save the best model after the interrupt: %v", saveErr)
+		}
+		return fmt.Errorf("experiment %s stopped at stage %s: %w", run.Experiment.Name, run.Stage, err)
+	}
+	return err
+}
+
+// trainStages runs the pipeline stages for TrainWithExperiment.
+func trainStages(ctx context.Context, bp *blueprint.Blueprint, sessions SessionSource, classOutputs []int, run *TrainingRun, logger *blueprint.PerformanceLogger) error {
+	exp := run.Experiment
+	search := exp.Search
+
+	if err := run.RestoreInto(bp); err != nil {
+		return err
+	}
+	if run.Blueprint == nil {
+		// Keep a snapshot to fall back on if the first round is interrupted
+		if err := run.snapshot(bp); err != nil {
+			return err
+		}
+	}
+
+	var validation SessionSource
+	stopping := search.EarlyStopping
+	if classOutputs != nil && stopping != nil && stopping.ValidationFraction > 0 {
+		// Hold out a stratified validation split; seeding from the run makes
+		// a resumed run hold out the same sessions
+		rng := rand.New(rand.NewSource(run.Metadata.Seed))
+		sessions, validation = stratifiedSplit(sessions, classOutputs, stopping.ValidationFraction, rng)
+		log.Printf("Training on %d sessions, validating on %d.", sessions.Len(), validation.Len())
+	}
+
+	// The sampler picks the training sessions of round k: batches of
+	// SessionBatchSize sessions, or as many as the training set holds
+	sampler, err := newSampler(search.Sampler, sessions, classOutputs, run.Metadata.Seed)
+	if err != nil {
+		return err
+	}
+	if fs, ok := sampler.(feedbackSampler); ok && run.Search.Weights != nil {
+		if err := fs.RestoreWeights(run.Search.Weights); err != nil {
+			return err
+		}
+	}
+	size := search.SessionBatchSize
+	if size <= 0 {
+		size = sessions.Len()
+	}
+	var all []blueprint.Session
+	batch := func(k int) []blueprint.Session {
+		if _, ok := sampler.(sequentialSampler); ok && size == sessions.Len() {
+			// Every round trains on the whole set in order; build it once
+			if all == nil {
+				all = sessionBatch(sessions, 0, size)
+			}
+			return all
+		}
+		return sessionsAt(sessions, sampler.Sample(k, size))
+	}
+
+	searchRound := func(iterations int) error {
+		k := run.Search.Round
+		indices := sampler.Sample(k, size)
+
+		var train []blueprint.Session
+		if aug := exp.Dataset.Augment; aug != nil && aug.Enabled() {
+			// Every round draws its own perturbations of the images
+			seed := aug.Seed
+			if seed == 0 {
+				seed = run.Metadata.Seed
+			}
+			rng := rand.New(rand.NewSource(seed + int64(k)*1000003))
+			train = augmentedSessions(sessions, indices, *aug, rng)
+		} else {
+			train = batch(k)
+		}
+
+		if err := runCancellable(ctx, func() { runSearch(bp, train, search, iterations) }); err != nil {
+			return err
+		}
+		if fs, ok := sampler.(feedbackSampler); ok {
+			fs.Update(bp, indices)
+			run.Search.Weights = fs.Weights()
+		}
+		return nil
+	}
+	checkpoint := func() error {
+		return run.Save(bp)
+	}
+
+	if run.Stage == stageSearch {
+		fmt.Printf("Training the model with %s...\n", search.Strategy)
+		// Run the search, in validated rounds when there is a validation split
+		if validation == nil {
+			if err := runInChunks(&run.Search, search.MaxIterations, exp.CheckpointEvery, searchRound, checkpoint); err != nil {
+				return err
+			}
+		} else if err := runWithEarlyStopping(bp, validation, classOutputs, search.MaxIterations, *stopping, &run.Search, searchRound, checkpoint); err != nil {
+			return err
+		}
+
+		if logger != nil {
+			// Evaluate and log performance for the training sessions
+			if err := bp.EvaluateAndLogPerformance(batch(run.Search.Round), logger); err != nil {
+				return fmt.Errorf("failed to evaluate and log performance: %w", err)
+			}
+
+			log.Println("Performance evaluation and logging completed successfully.")
+		}
+
+		if err := run.Advance(bp, stagePostProcessing); err != nil {
+			return err
+		}
+	}
+
+	if run.Stage == stagePostProcessing {
+		for run.NextStep < len(exp.PostProcessing) {
+			step := exp.PostProcessing[run.NextStep]
+			batch := batch(run.Search.Round + run.NextStep)
+			if err := runCancellable(ctx, func() { runPostProcessStep(bp, batch, step) }); err != nil {
+				return err
+			}
+			run.NextStep++
+			if err := checkpoint(); err != nil {
+				return err
+			}
+		}
+
+		// Ensure the output directory exists
+		if err := os.MkdirAll(filepath.Dir(exp.Output), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		if err := SaveModelJSON(bp, exp.Output, run.Metadata); err != nil {
+			return fmt.Errorf("failed to save model: %w", err)
+		}
+		fmt.Printf("\nTraining complete. Model saved to %s\n", exp.Output)
+
+		if err := run.Advance(bp, stageEvaluation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSearch runs iterations of the configured search strategy on the network.
+func runSearch(bp *blueprint.Blueprint, sessions []blueprint.Session, search SearchConfig, iterations int) {
+	switch search.Strategy {
+	case strategySimpleNAS:
+		bp.SimpleNAS(sessions, iterations)
+	case strategySimpleNASWithoutCrossover:
+		bp.SimpleNASWithoutCrossover(sessions, iterations, search.ForgivenessThreshold, search.NeuronTypes, search.Metrics)
+	case strategyRandomConnections:
+		bp.SimpleNASWithRandomConnections(sessions, iterations, search.ForgivenessThreshold, search.NeuronTypes, search.WeightUpdateIterations)
+	case strategyAdvancedParallelNAS:
+		bp.AdvancedParallelNASWithDynamicNeuronGeneration(
+			sessions,
+			iterations,
+			search.NeuronTypes,
+			search.WeightUpdateIterations,
+			search.UseHillClimbing,
+			search.SaveImprovedModel,
+			search.SaveLocation,
+			search.MaxTriesWithoutImprovement,
+			search.Batches,
+		)
+	}
+}
+
+// runInChunks runs the search in chunks of chunkSize iterations (all at once
+// when chunkSize is not positive), calling afterChunk after each one.
+func runInChunks(progress *SearchProgress, maxIterations, chunkSize int, searchRound func(iterations int) error, afterChunk func() error) error {
+	for progress.Iteration < maxIterations {
+		iterations := chunkSize
+		if iterations <= 0 || iterations > maxIterations-progress.Iteration {
+			iterations = maxIterations - progress.Iteration
+		}
+		if err := searchRound(iterations); err != nil {
+			return err
+		}
+		progress.Iteration += iterations
+		progress.Round++
+		log.Printf("Search round %d done (%d/%d iterations).", progress.Round, progress.Iteration, maxIterations)
+
+		if err := afterChunk(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostProcessStep applies one post-processing step to the trained network.
+func runPostProcessStep(bp *blueprint.Blueprint, sessions []blueprint.Session, step PostProcessStep) {
+	switch step.Step {
+	case stepTargetedMicroRefinement:
+		fmt.Println("Applying Targeted Micro Refinement...")
+		bp.TargetedMicroRefinement(
+			sessions,
+			step.MaxIterations,
+			step.SampleSubsetSize,
+			step.ConnectionTrialsPerSample,
+			step.ImprovementThreshold,
+		)
+	case stepTryAddConnections:
+		fmt.Println("Trying to add new connections to improve accuracy...")
+		bp.TryAddConnections(sessions, step.Attempts)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Magic numbers of the IDX files used by MNIST: unsigned bytes with three
+// dimensions for images and one dimension for labels.
+const (
+	idxImageMagic = 0x00000803
+	idxLabelMagic = 0x00000801
+)
+
+// idxReader streams samples from a pair of idx3-ubyte image and idx1-ubyte label files.
+type idxReader struct {
+	imgFile *os.File
+	lblFile *os.File
+	images  *bufio.Reader
+	labels  *bufio.Reader
+
+	Count int // number of samples in the files
+	Rows  int // image height in pixels
+	Cols  int // image width in pixels
+
+	next   int
+	pixels []byte
+}
+
+// openIDX opens an image/label file pair and validates their headers.
+func openIDX(imageFile, labelFile string) (*idxReader, error) {
+	imgFile, err := os.Open(imageFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file: %w", err)
+	}
+
+	lblFile, err := os.Open(labelFile)
+	if err != nil {
+		imgFile.Close()
+		return nil, fmt.Errorf("failed to open label file: %w", err)
+	}
+
+	r := &idxReader{
+		imgFile: imgFile,
+		lblFile: lblFile,
+		images:  bufio.NewReader(imgFile),
+		labels:  bufio.NewReader(lblFile),
+	}
+	if err := r.readHeaders(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// readHeaders parses the image and label headers and checks that they agree.
+func (r *idxReader) readHeaders() error {
+	var imgHeader [16]byte
+	if _, err := io.ReadFull(r.images, imgHeader[:]); err != nil {
+		return fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	var lblHeader [8]byte
+	if _, err := io.ReadFull(r.labels, lblHeader[:]); err != nil {
+		return fmt.Errorf("failed to read label header: %w", err)
+	}
+
+	if magic := binary.BigEndian.Uint32(imgHeader[0:4]); magic != idxImageMagic {
+		return fmt.Errorf("unexpected image file magic number 0x%08x", magic)
+	}
+	if magic := binary.BigEndian.Uint32(lblHeader[0:4]); magic != idxLabelMagic {
+		return fmt.Errorf("unexpected label file magic number 0x%08x", magic)
+	}
+
+	numImages := binary.BigEndian.Uint32(imgHeader[4:8])
+	numLabels := binary.BigEndian.Uint32(lblHeader[4:8])
+	if numImages != numLabels {
+		return fmt.Errorf("image and label count mismatch: %d images, %d labels", numImages, numLabels)
+	}
+
+	r.Count = int(numImages)
+	r.Rows = int(binary.BigEndian.Uint32(imgHeader[8:12]))
+	r.Cols = int(binary.BigEndian.Uint32(imgHeader[12:16]))
+	r.pixels = make([]byte, r.Rows*r.Cols)
+	return nil
+}
+
+// Next returns the pixels and label of the next sample, or io.EOF once every
+// sample has been read. The returned slice is reused by the following call.
+func (r *idxReader) Next() ([]byte, int, error) {
+	if r.next >= r.Count {
+		return nil, 0, io.EOF
+	}
+
+	if _, err := io.ReadFull(r.images, r.pixels); err != nil {
+		return nil, 0, fmt.Errorf("failed to read image %d: %w", r.next, err)
+	}
+
+	label, err := r.labels.ReadByte()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read label %d: %w", r.next, err)
+	}
+
+	r.next++
+	return r.pixels, int(label), nil
+}
+
+// Close releases both underlying files.
+func (r *idxReader) Close() error {
+	imgErr := r.imgFile.Close()
+	lblErr := r.lblFile.Close()
+	if imgErr != nil {
+		return imgErr
+	}
+	return lblErr
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// idxFile writes an IDX file with writeIDX and returns its path.
+func idxFile(t *testing.T, name string, magic uint32, dims []uint32, data []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeIDX(&buf, magic, dims, data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIDXReader(t *testing.T) {
+	images := idxFile(t, "images", idxImageMagic, []uint32{2, 2, 3}, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	labels := idxFile(t, "labels", idxLabelMagic, []uint32{2}, []byte{7, 3})
+
+	r, err := openIDX(images, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Count != 2 || r.Rows != 2 || r.Cols != 3 {
+		t.Fatalf("got %d samples of %dx%d, want 2 of 2x3", r.Count, r.Rows, r.Cols)
+	}
+
+	want := []struct {
+		pixels []byte
+		label  int
+	}{
+		{[]byte{1, 2, 3, 4, 5, 6}, 7},
+		{[]byte{7, 8, 9, 10, 11, 12}, 3},
+	}
+	for i, w := range want {
+		pixels, label, err := r.Next()
+		if err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(pixels, w.pixels) || label != w.label {
+			t.Errorf("sample %d = %v, %d; want %v, %d", i, pixels, label, w.pixels, w.label)
+		}
+	}
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last sample: got %v, want io.EOF", err)
+	}
+}
+
+func TestIDXReaderErrors(t *testing.T) {
+	images := func(t *testing.T) string {
+		return idxFile(t, "images", idxImageMagic, []uint32{2, 1, 1}, []byte{1, 2})
+	}
+	tests := []struct {
+		name    string
+		images  func(t *testing.T) string
+		labels  func(t *testing.T) string
+		wantErr string
+	}{
+		{
+			name:    "wrong image magic",
+			images:  func(t *testing.T) string { return idxFile(t, "images", idxLabelMagic, []uint32{2, 1, 1}, nil) },
+			labels:  func(t *testing.T) string { return idxFile(t, "labels", idxLabelMagic, []uint32{2}, nil) },
+			wantErr: "unexpected image file magic number 0x00000801",
+		},
+		{
+			name:    "wrong label magic",
+			images:  images,
+			labels:  func(t *testing.T) string { return idxFile(t, "labels", idxImageMagic, []uint32{2}, nil) },
+			wantErr: "unexpected label file magic number 0x00000803",
+		},
+		{
+			name:    "count mismatch",
+			images:  images,
+			labels:  func(t *testing.T) string { return idxFile(t, "labels", idxLabelMagic, []uint32{3}, nil) },
+			wantErr: "image and label count mismatch: 2 images, 3 labels",
+		},
+		{
+			name:    "truncated header",
+			images:  func(t *testing.T) string { return idxFile(t, "images", idxImageMagic, []uint32{2}, nil) },
+			labels:  func(t *testing.T) string { return idxFile(t, "labels", idxLabelMagic, []uint32{2}, nil) },
+			wantErr: "failed to read image header",
+		},
+		{
+			name:    "missing file",
+			images:  func(t *testing.T) string { return filepath.Join(t.TempDir(), "none") },
+			labels:  func(t *testing.T) string { return idxFile(t, "labels", idxLabelMagic, []uint32{2}, nil) },
+			wantErr: "failed to open image file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := openIDX(tt.images(t), tt.labels(t))
+			if err == nil {
+				r.Close()
+				t.Fatalf("got no error, want %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDXReaderTruncatedData(t *testing.T) {
+	images := idxFile(t, "images", idxImageMagic, []uint32{2, 1, 2}, []byte{1, 2, 3})
+	labels := idxFile(t, "labels", idxLabelMagic, []uint32{2}, []byte{0, 1})
+	r, err := openIDX(images, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, _, err := r.Next(); err != nil {
+		t.Fatalf("first sample: %v", err)
+	}
+	if _, _, err := r.Next(); err == nil || !strings.Contains(err.Error(), "failed to read image 1") {
+		t.Errorf("second sample: got %v, want a read error", err)
+	}
+}
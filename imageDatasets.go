@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// imageOutputBase is the ID of the output neuron for class 0 of an image
+// dataset; class i is read from imageOutputBase+i.
+const imageOutputBase = 80001
+
+// Storage formats of image datasets.
+const (
+	formatIDX   = ""      // four gzipped IDX archives in the MNIST layout
+	formatCIFAR = "cifar" // one .tar.gz of CIFAR-10 binary batches
+)
+
+// ImageDataset describes a labelled image dataset published either as four
+// gzipped IDX archives in the MNIST layout or as a CIFAR-10 binary archive.
+type ImageDataset struct {
+	Name        string
+	Description string
+	Format      string
+	// BaseURL is the public mirror the archives are downloaded from; datasets
+	// without one must be given a --data-source.
+	BaseURL string
+	// Dir is the local directory the archives are stored and unzipped in.
+	Dir string
+
+	// TrainImages to TestLabels name the archives of an IDX dataset.
+	TrainImages, TrainLabels string
+	TestImages, TestLabels   string
+	// Archive names the single archive of a CIFAR dataset.
+	Archive string
+
+	// Checksums maps archive names to "md5:<hex>" or "sha256:<hex>" digests.
+	// Archives without an entry are not verified.
+	Checksums map[string]string
+
+	ClassNames []string
+	// LabelOffset is subtracted from the stored labels to get class indices
+	// (EMNIST letters are labelled 1-26).
+	LabelOffset int
+	// Transposed marks datasets that store images column by column (EMNIST).
+	Transposed bool
+}
+
+// Archives returns the names of the archives of the dataset.
+func (d ImageDataset) Archives() []string {
+	if d.Format == formatCIFAR {
+		return []string{d.Archive}
+	}
+	return []string{d.TrainImages, d.TrainLabels, d.TestImages, d.TestLabels}
+}
+
+// Classes returns the number of classes.
+func (d ImageDataset) Classes() int {
+	return len(d.ClassNames)
+}
+
+// OutputNodes returns the output neuron IDs of the classes in order.
+func (d ImageDataset) OutputNodes() []int {
+	nodes := make([]int, d.Classes())
+	for i := range nodes {
+		nodes[i] = imageOutputBase + i
+	}
+	return nodes
+}
+
+// unzipped returns the file name an archive is extracted to.
+func unzipped(archive string) string {
+	return strings.TrimSuffix(archive, ".gz")
+}
+
+// mnistStyleArchives returns the archive names used by MNIST and its drop-in
+// replacements.
+func mnistStyleArchives(d ImageDataset) ImageDataset {
+	d.TrainImages = "train-images-idx3-ubyte.gz"
+	d.TrainLabels = "train-labels-idx1-ubyte.gz"
+	d.TestImages = "t10k-images-idx3-ubyte.gz"
+	d.TestLabels = "t10k-labels-idx1-ubyte.gz"
+	return d
+}
+
+// emnistSplit describes one split of the EMNIST archive, which NIST only
+// publishes as a single zip file.
+func emnistSplit(split string, classNames []string, labelOffset int) ImageDataset {
+	prefix := "emnist-" + split + "-"
+	return ImageDataset{
+		Name:        "emnist-" + split,
+		Description: fmt.Sprintf("EMNIST %s, %d classes (extract gzip.zip from NIST and pass --data-source)", split, len(classNames)),
+		Dir:         "emnist-" + split,
+		TrainImages: prefix + "train-images-idx3-ubyte.gz",
+		TrainLabels: prefix + "train-labels-idx1-ubyte.gz",
+		TestImages:  prefix + "test-images-idx3-ubyte.gz",
+		TestLabels:  prefix + "test-labels-idx1-ubyte.gz",
+		ClassNames:  classNames,
+		LabelOffset: labelOffset,
+		Transposed:  true,
+	}
+}
+
+// characters returns the characters of s as one-letter strings.
+func characters(s string) []string {
+	return strings.Split(s, "")
+}
+
+// imageDatasets is the registry of datasets the image scenarios can train on.
+var imageDatasets = map[string]ImageDataset{
+	"mnist": mnistStyleArchives(ImageDataset{
+		Name:        "mnist",
+		Description: "MNIST handwritten digits",
+		BaseURL:     "https://storage.googleapis.com/cvdf-datasets/mnist/",
+		Dir:         "mnist",
+		Checksums: map[string]string{
+			"train-images-idx3-ubyte.gz": "sha256:440fcabf73cc546fa21475e81ea370265605f56be210a4024d2ca8f203523609",
+			"train-labels-idx1-ubyte.gz": "sha256:3552534a0a558bbed6aed32b30c495cca23d567ec52cac8be1a0730e8010255c",
+			"t10k-images-idx3-ubyte.gz":  "sha256:8d422c7b0a1c1c79245a5bcf07fe86e33eeafee792b84584aec276f5a2dbc4e6",
+			"t10k-labels-idx1-ubyte.gz":  "sha256:f7ae60f92e00ec6debd23a6088c31dbd2371eca3ffa0defaefb259924204aec6",
+		},
+		ClassNames: characters("0123456789"),
+	}),
+	"fashion-mnist": mnistStyleArchives(ImageDataset{
+		Name:        "fashion-mnist",
+		Description: "Zalando Fashion-MNIST clothing images",
+		BaseURL:     "http://fashion-mnist.s3-website.eu-central-1.amazonaws.com/",
+		Dir:         "fashion-mnist",
+		Checksums: map[string]string{
+			"train-images-idx3-ubyte.gz": "md5:8d4fb7e6c68d591d4c3dfef9ec88bf0d",
+			"train-labels-idx1-ubyte.gz": "md5:25c81989df183df01b3e8a0aad5dffbe",
+			"t10k-images-idx3-ubyte.gz":  "md5:bef4ecab320f06d8554ea6380940ec79",
+			"t10k-labels-idx1-ubyte.gz":  "md5:bb300cfdad3c16e7a12a480ee83cd310",
+		},
+		ClassNames: []string{"T-shirt/top", "Trouser", "Pullover", "Dress", "Coat", "Sandal", "Shirt", "Sneaker", "Bag", "Ankle boot"},
+	}),
+	"kmnist": mnistStyleArchives(ImageDataset{
+		Name:        "kmnist",
+		Description: "Kuzushiji-MNIST cursive Japanese characters",
+		BaseURL:     "http://codh.rois.ac.jp/kmnist/dataset/kmnist/",
+		Dir:         "kmnist",
+		Checksums: map[string]string{
+			"train-images-idx3-ubyte.gz": "md5:bdb82020997e1d708af4cf47b453dcf7",
+			"train-labels-idx1-ubyte.gz": "md5:e144d726b3acfaa3e44228e80efcd344",
+			"t10k-images-idx3-ubyte.gz":  "md5:5c965bf0a639b31b8f53240b1b52f4d7",
+			"t10k-labels-idx1-ubyte.gz":  "md5:7320c461ea6c1c855c0b718fb2a4b134",
+		},
+		ClassNames: []string{"o", "ki", "su", "tsu", "na", "ha", "ma", "ya", "re", "wo"},
+	}),
+	"cifar-10": {
+		Name:        "cifar-10",
+		Description: "CIFAR-10 32x32 colour images",
+		Format:      formatCIFAR,
+		BaseURL:     "https://www.cs.toronto.edu/~kriz/",
+		Dir:         "cifar-10",
+		Archive:     "cifar-10-binary.tar.gz",
+		Checksums: map[string]string{
+			"cifar-10-binary.tar.gz": "md5:c32a1d4ab5d03f1284b67883e8d87530",
+		},
+		ClassNames: []string{"airplane", "automobile", "bird", "cat", "deer", "dog", "frog", "horse", "ship", "truck"},
+	},
+	"emnist-digits":   emnistSplit("digits", characters("0123456789"), 0),
+	"emnist-letters":  emnistSplit("letters", characters("ABCDEFGHIJKLMNOPQRSTUVWXYZ"), 1),
+	"emnist-balanced": emnistSplit("balanced", characters("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabdefghnqrt"), 0),
+}
+
+// findImageDataset looks up a registered image dataset by name.
+func findImageDataset(name string) (ImageDataset, error) {
+	ds, ok := imageDatasets[name]
+	if !ok {
+		return ImageDataset{}, fmt.Errorf("unknown image dataset %q (available: %s)", name, strings.Join(imageDatasetNames(), ", "))
+	}
+	return ds, nil
+}
+
+// imageDatasetNames returns the registered dataset names in sorted order.
+func imageDatasetNames() []string {
+	names := make([]string, 0, len(imageDatasets))
+	for name := range imageDatasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,19 +1,17 @@
 package main
 
 import (
-	"blueprint"
 	"fmt"
 )
 
-func testBlueprintMethods() {
+func testBlueprintMethods(rc *RunContext) error {
 	// Create an instance of the Blueprint struct
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Retrieve methods metadata as JSON
 	methodsJSON, err := bp.GetBlueprintMethodsJSON()
 	if err != nil {
-		fmt.Printf("Error retrieving Blueprint methods: %v\n", err)
-		return
+		return fmt.Errorf("failed to retrieve blueprint methods: %w", err)
 	}
 
 	// Display the JSON output
@@ -23,8 +21,7 @@ func testBlueprintMethods() {
 	// Alternatively, retrieve the raw MethodInfo structs
 	methods, err := bp.GetBlueprintMethods()
 	if err != nil {
-		fmt.Printf("Error retrieving Blueprint methods: %v\n", err)
-		return
+		return fmt.Errorf("failed to retrieve blueprint methods: %w", err)
 	}
 
 	// Print method details in a human-readable format
@@ -37,4 +34,5 @@ func testBlueprintMethods() {
 		}
 		fmt.Println()
 	}
+	return nil
 }
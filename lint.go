@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recurrentNeuronTypes may take part in cycles: they read the previous
+// timestep's state, so a loop through them is not an infinite recursion.
+var recurrentNeuronTypes = map[string]bool{"rnn": true, "lstm": true, "nca": true}
+
+// LintProblem is one finding in a neuron config, located by byte offset and
+// by line and column (both from 1). Warnings flag names the linter does not
+// know when unknown names are allowed.
+type LintProblem struct {
+	Offset  int
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+func (p LintProblem) String() string {
+	if p.Warning {
+		return fmt.Sprintf("%d:%d: warning: %s", p.Line, p.Column, p.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// lintNeuron is a neuron of the config with the offsets of its parts.
+type lintNeuron struct {
+	pos    int            // start of the neuron's object
+	fields map[string]int // start of each field's value
+
+	id           int
+	hasID        bool
+	kind         string
+	activation   string
+	updateRules  string
+	connections  [][]float64
+	connPos      []int
+	neighborhood []int
+	neighPos     []int
+	kernels      [][]float64
+}
+
+// linter collects the problems of one config.
+type linter struct {
+	data         []byte
+	allowUnknown bool // report unknown types, activations and update rules as warnings
+	problems     []LintProblem
+}
+
+// report records a problem at the given byte offset.
+func (l *linter) report(offset int, format string, args ...interface{}) {
+	line, col := lineColumn(l.data, offset)
+	l.problems = append(l.problems, LintProblem{Offset: offset, Line: line, Column: col, Message: fmt.Sprintf(format, args...)})
+}
+
+// reportUnknown records an unknown name at the given byte offset, as a
+// warning if unknown names are allowed.
+func (l *linter) reportUnknown(offset int, format string, args ...interface{}) {
+	l.report(offset, format, args...)
+	l.problems[len(l.problems)-1].Warning = l.allowUnknown
+}
+
+// lineColumn converts a byte offset into a line and a byte column.
+func lineColumn(data []byte, offset int) (line, col int) {
+	line, col = 1, 1
+	for _, c := range data[:min(offset, len(data))] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// LintNeuronConfig checks a neuron config as LoadNeurons reads it and returns
+// its problems in the order they appear:
+//   - malformed JSON and fields of the wrong type
+//   - missing and duplicate IDs
+//   - connections and nca neighbourhoods naming missing neurons
+//   - cnn kernels whose size differs from each other or from the connections
+//   - cycles that do not pass through a recurrent neuron
+//   - output neurons no input neuron reaches
+//   - fields blueprint.Neuron does not have
+//   - unknown neuron types, activations and nca update rules
+//
+// The last are checked against hammer's own lists, which may fall behind the
+// framework; allowUnknown reports them as warnings instead.
+func LintNeuronConfig(data []byte, allowUnknown bool) []LintProblem {
+	l := &linter{data: data, allowUnknown: allowUnknown}
+	if err := json.Unmarshal(data, new(interface{})); err != nil {
+		var syntax *json.SyntaxError
+		offset := len(data)
+		if errors.As(err, &syntax) {
+			offset = max(int(syntax.Offset)-1, 0)
+		}
+		l.report(offset, "invalid JSON: %v", err)
+		return l.problems
+	}
+
+	neurons := l.parse()
+	l.check(neurons)
+	sort.SliceStable(l.problems, func(i, j int) bool { return l.problems[i].Offset < l.problems[j].Offset })
+	return l.problems
+}
+
+// skipSeparators returns the offset of the next value at or after offset.
+func skipSeparators(data []byte, offset int) int {
+	for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// elements returns the values of a JSON array with their offsets, or false
+// if raw is not an array.
+func elements(raw json.RawMessage, base int) ([]json.RawMessage, []int, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, nil, false
+	}
+	var values []json.RawMessage
+	var offsets []int
+	for dec.More() {
+		start := skipSeparators(raw, int(dec.InputOffset()))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, false
+		}
+		values = append(values, value)
+		offsets = append(offsets, base+start)
+	}
+	return values, offsets, true
+}
+
+// parse reads the neurons of the config, reporting values of the wrong shape.
+func (l *linter) parse() []*lintNeuron {
+	items, offsets, ok := elements(l.data, 0)
+	if !ok {
+		l.report(skipSeparators(l.data, 0), "expected a JSON array of neurons")
+		return nil
+	}
+
+	allowed := make(map[string]bool)
+	for i := 0; i < neuronType.NumField(); i++ {
+		if name, ok := jsonFieldName(neuronType.Field(i)); ok {
+			allowed[name] = true
+		}
+	}
+	var neurons []*lintNeuron
+	for i, item := range items {
+		n := &lintNeuron{pos: offsets[i], fields: make(map[string]int)}
+		dec := json.NewDecoder(bytes.NewReader(item))
+		if tok, _ := dec.Token(); tok != json.Delim('{') {
+			l.report(n.pos, "neuron must be an object")
+			continue
+		}
+		for dec.More() {
+			keyPos := n.pos + skipSeparators(item, int(dec.InputOffset()))
+			keyTok, _ := dec.Token()
+			key, _ := keyTok.(string)
+			start := n.pos + skipSeparators(item, int(dec.InputOffset()))
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				break
+			}
+			n.fields[key] = start
+			if !allowed[key] {
+				l.report(keyPos, "unknown field %q", key)
+				continue
+			}
+			l.parseField(n, key, value, start)
+		}
+		neurons = append(neurons, n)
+	}
+	return neurons
+}
+
+// parseField decodes one field of a neuron. A null list is an empty one, as
+// for LoadNeurons.
+func (l *linter) parseField(n *lintNeuron, key string, value json.RawMessage, start int) {
+	if string(value) == "null" {
+		switch key {
+		case "connections", "neighborhood", "kernels":
+			return
+		}
+	}
+
+	decode := func(v interface{}, want string) bool {
+		if err := json.Unmarshal(value, v); err != nil {
+			l.report(start, "%s must be %s", key, want)
+			return false
+		}
+		return true
+	}
+
+	switch key {
+	case "id":
+		n.hasID = decode(&n.id, "an integer")
+	case "type":
+		decode(&n.kind, "a string")
+	case "activation":
+		decode(&n.activation, "a string")
+	case "update_rules":
+		decode(&n.updateRules, "a string")
+	case "value", "bias":
+		decode(new(float64), "a number")
+	case "dropout_rate":
+		var rate float64
+		if decode(&rate, "a number") && (rate < 0 || rate > 1) {
+			l.report(start, "dropout_rate %g is outside [0, 1]", rate)
+		}
+	case "kernels":
+		decode(&n.kernels, "a list of lists of numbers")
+	case "neighborhood":
+		values, offsets, ok := elements(value, start)
+		if !ok {
+			l.report(start, "neighborhood must be a list of neuron IDs")
+			return
+		}
+		for i, v := range values {
+			var id int
+			if err := json.Unmarshal(v, &id); err != nil {
+				l.report(offsets[i], "neighbour must be a neuron ID")
+				continue
+			}
+			n.neighborhood = append(n.neighborhood, id)
+			n.neighPos = append(n.neighPos, offsets[i])
+		}
+	case "connections":
+		values, offsets, ok := elements(value, start)
+		if !ok {
+			l.report(start, "connections must be a list of [source, weight] pairs")
+			return
+		}
+		for i, v := range values {
+			var pair []float64
+			if err := json.Unmarshal(v, &pair); err != nil || len(pair) != 2 || pair[0] != float64(int(pair[0])) {
+				l.report(offsets[i], "connection must be a [source neuron ID, weight] pair")
+				continue
+			}
+			n.connections = append(n.connections, pair)
+			n.connPos = append(n.connPos, offsets[i])
+		}
+	}
+}
+
+// check reports the problems that involve the network as a whole.
+func (l *linter) check(neurons []*lintNeuron) {
+	byID := make(map[int]*lintNeuron)
+	for _, n := range neurons {
+		if !n.hasID {
+			if _, ok := n.fields["id"]; !ok {
+				l.report(n.pos, "neuron has no id")
+			}
+			continue
+		}
+		if first, dup := byID[n.id]; dup {
+			l.report(n.fields["id"], "duplicate neuron ID %d, first used at %s", n.id, l.position(first.fields["id"]))
+			continue
+		}
+		byID[n.id] = n
+	}
+
+	for _, n := range neurons {
+		if _, ok := n.fields["type"]; !ok {
+			l.report(n.pos, "neuron has no type")
+		} else if n.kind != "" && !contains(knownNeuronTypes, n.kind) {
+			l.reportUnknown(n.fields["type"], "unknown neuron type %q (known: %s)", n.kind, strings.Join(knownNeuronTypes, ", "))
+		}
+		if n.activation != "" && !contains(knownActivations, n.activation) {
+			l.reportUnknown(n.fields["activation"], "unknown activation %q (known: %s)", n.activation, strings.Join(knownActivations, ", "))
+		}
+		if n.updateRules != "" && !contains(knownUpdateRules, n.updateRules) {
+			l.reportUnknown(n.fields["update_rules"], "unknown update rule %q (known: %s)", n.updateRules, strings.Join(knownUpdateRules, ", "))
+		}
+
+		for i, c := range n.connections {
+			if _, ok := byID[int(c[0])]; !ok {
+				l.report(n.connPos[i], "connection from missing neuron %d", int(c[0]))
+			}
+		}
+		for i, id := range n.neighborhood {
+			if _, ok := byID[id]; !ok {
+				l.report(n.neighPos[i], "neighbour %d does not exist", id)
+			}
+		}
+		if n.kind == "nca" && len(n.neighborhood) == 0 {
+			l.report(n.pos, "nca neuron %d has no neighborhood", n.id)
+		}
+		l.checkKernels(n)
+	}
+
+	l.checkCycles(neurons, byID)
+	l.checkReachable(neurons, byID)
+}
+
+// checkKernels reports cnn kernels of unequal size, or of a size other than
+// the number of connections they are applied to.
+func (l *linter) checkKernels(n *lintNeuron) {
+	if len(n.kernels) == 0 {
+		return
+	}
+	pos := n.fields["kernels"]
+	if n.kind != "cnn" {
+		l.report(pos, "kernels are only used by cnn neurons, not %s", n.kind)
+		return
+	}
+	size := len(n.kernels[0])
+	for i, kernel := range n.kernels {
+		if len(kernel) != size {
+			l.report(pos, "kernel %d has %d weights, kernel 1 has %d", i+1, len(kernel), size)
+			return
+		}
+	}
+	if _, ok := n.fields["connections"]; ok && size != len(n.connections) {
+		l.report(pos, "kernels have %d weights, but the neuron has %d connections", size, len(n.connections))
+	}
+}
+
+// checkCycles reports every cycle of connections between non-recurrent
+// neurons once, at the connection that closes it.
+func (l *linter) checkCycles(neurons []*lintNeuron, byID map[int]*lintNeuron) {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[int]int)
+	var path []int
+	seen := make(map[string]bool)
+
+	var visit func(n *lintNeuron)
+	visit = func(n *lintNeuron) {
+		state[n.id] = active
+		path = append(path, n.id)
+		for i, c := range n.connections {
+			source, ok := byID[int(c[0])]
+			if !ok || recurrentNeuronTypes[source.kind] {
+				continue
+			}
+			switch state[source.id] {
+			case unvisited:
+				visit(source)
+			case active:
+				// The path from source back to n, followed by the connection
+				start := len(path) - 1
+				for path[start] != source.id {
+					start--
+				}
+				cycle := append([]int(nil), path[start:]...)
+				key := cycleKey(cycle)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				names := make([]string, len(cycle)+1)
+				for j, id := range cycle {
+					names[j] = strconv.Itoa(id)
+				}
+				names[len(cycle)] = names[0]
+				// path runs against the flow of data; print it along the flow
+				for a, b := 0, len(names)-1; a < b; a, b = a+1, b-1 {
+					names[a], names[b] = names[b], names[a]
+				}
+				l.report(n.connPos[i], "cycle among non-recurrent neurons: %s (route it through an rnn or lstm neuron)", strings.Join(names, " -> "))
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.id] = done
+	}
+
+	for _, n := range neurons {
+		if n.hasID && byID[n.id] == n && !recurrentNeuronTypes[n.kind] && state[n.id] == unvisited {
+			visit(n)
+		}
+	}
+}
+
+// cycleKey identifies a cycle regardless of the neuron it starts at.
+func cycleKey(cycle []int) string {
+	ids := append([]int(nil), cycle...)
+	sort.Ints(ids)
+	return fmt.Sprint(ids)
+}
+
+// checkReachable reports output neurons that no input neuron feeds, directly
+// or through other neurons' connections and nca neighbourhoods.
+func (l *linter) checkReachable(neurons []*lintNeuron, byID map[int]*lintNeuron) {
+	readers := make(map[int][]int) // neuron ID -> neurons reading it
+	var queue []int
+	reached := make(map[int]bool)
+	for id, n := range byID {
+		for _, c := range n.connections {
+			readers[int(c[0])] = append(readers[int(c[0])], id)
+		}
+		for _, source := range n.neighborhood {
+			readers[source] = append(readers[source], id)
+		}
+		if n.kind == "input" {
+			queue = append(queue, id)
+			reached[id] = true
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, reader := range readers[id] {
+			if !reached[reader] {
+				reached[reader] = true
+				queue = append(queue, reader)
+			}
+		}
+	}
+
+	for _, n := range neurons {
+		if n.kind == "output" && n.hasID && byID[n.id] == n && !reached[n.id] {
+			l.report(n.pos, "output neuron %d is not reachable from any input neuron", n.id)
+		}
+	}
+}
+
+// position formats an offset as line:column.
+func (l *linter) position(offset int) string {
+	line, col := lineColumn(l.data, offset)
+	return fmt.Sprintf("%d:%d", line, col)
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintNeuronConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		allowUnknown bool
+		want         []string // problems as line:column: message, messages cut at " ("
+	}{
+		{
+			name: "valid",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"id": 2, "type": "output", "activation": "relu", "connections": [[1, 0.5]]}
+]`,
+		},
+		{
+			name:   "invalid JSON",
+			config: `[{"id": 1,}]`,
+			want:   []string{"1:11: invalid JSON: invalid character '}' looking for beginning of object key string"},
+		},
+		{
+			name:   "not a list",
+			config: `{"id": 1}`,
+			want:   []string{"1:1: expected a JSON array of neurons"},
+		},
+		{
+			name: "missing and duplicate IDs",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"type": "dense"},
+  {"id": 1, "type": "output"}
+]`,
+			want: []string{
+				"3:3: neuron has no id",
+				"4:10: duplicate neuron ID 1, first used at 2:10",
+			},
+		},
+		{
+			name: "wrong field types",
+			config: `[
+  {"id": "one", "type": 2, "dropout_rate": 1.5}
+]`,
+			want: []string{
+				"2:10: id must be an integer",
+				"2:25: type must be a string",
+				"2:44: dropout_rate 1.5 is outside [0, 1]",
+			},
+		},
+		{
+			name: "missing neighbours and connections",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"id": 2, "type": "nca", "neighborhood": [1, 7], "connections": [[9, 1]]},
+  {"id": 3, "type": "output", "connections": [[2, 1]]}
+]`,
+			want: []string{
+				"3:48: neighbour 7 does not exist",
+				"3:68: connection from missing neuron 9",
+			},
+		},
+		{
+			name: "null lists",
+			config: `[
+  {"id": 1, "type": "input", "neighborhood": null, "kernels": null},
+  {"id": 2, "type": "output", "connections": null, "batch_norm_params": null}
+]`,
+			want: []string{"3:3: output neuron 2 is not reachable from any input neuron"},
+		},
+		{
+			name: "nca without neighbours",
+			config: `[
+  {"id": 1, "type": "nca", "neighborhood": null}
+]`,
+			want: []string{"2:3: nca neuron 1 has no neighborhood"},
+		},
+		{
+			name: "kernels",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"id": 2, "type": "cnn", "connections": [[1, 1]], "kernels": [[1, 2], [3]]},
+  {"id": 3, "type": "dense", "connections": [[2, 1]], "kernels": [[1]]}
+]`,
+			want: []string{
+				"3:64: kernel 2 has 1 weights, kernel 1 has 2",
+				"4:66: kernels are only used by cnn neurons, not dense",
+			},
+		},
+		{
+			name: "cycle through dense neurons",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"id": 2, "type": "dense", "connections": [[1, 1], [3, 1]]},
+  {"id": 3, "type": "dense", "connections": [[2, 1]]},
+  {"id": 4, "type": "output", "connections": [[3, 1]]}
+]`,
+			want: []string{"4:46: cycle among non-recurrent neurons: 2 -> 3 -> 2"},
+		},
+		{
+			name: "cycle through a recurrent neuron",
+			config: `[
+  {"id": 1, "type": "input"},
+  {"id": 2, "type": "rnn", "connections": [[1, 1], [3, 1]]},
+  {"id": 3, "type": "dense", "connections": [[2, 1]]},
+  {"id": 4, "type": "output", "connections": [[3, 1]]}
+]`,
+		},
+		{
+			name: "unknown names",
+			config: `[
+  {"id": 1, "type": "inptu", "activation": "swish", "colour": "red"},
+  {"id": 2, "type": "nca", "neighborhood": [1], "update_rules": "max"}
+]`,
+			want: []string{
+				"2:21: unknown neuron type \"inptu\"",
+				"2:44: unknown activation \"swish\"",
+				"2:53: unknown field \"colour\"",
+				"3:65: unknown update rule \"max\"",
+			},
+		},
+		{
+			name: "unknown names allowed",
+			config: `[
+  {"id": 1, "type": "inptu", "activation": "swish", "colour": "red"},
+  {"id": 2, "type": "nca", "neighborhood": [1], "update_rules": "max"}
+]`,
+			allowUnknown: true,
+			want: []string{
+				"2:21: warning: unknown neuron type \"inptu\"",
+				"2:44: warning: unknown activation \"swish\"",
+				"2:53: unknown field \"colour\"",
+				"3:65: warning: unknown update rule \"max\"",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range LintNeuronConfig([]byte(tt.config), tt.allowUnknown) {
+				msg, _, _ := strings.Cut(p.String(), " (")
+				got = append(got, msg)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got problems\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLintNeuronConfigKnowsEveryNeuronField(t *testing.T) {
+	config := `[{"id": 1, "type": "input", "value": 0, "bias": 0, "connections": [], "activation": "linear",
+  "loop_count": 1, "window_size": 1, "dropout_rate": 0, "batch_norm": false, "batch_norm_params": null,
+  "attention": false, "attention_weights": null, "kernels": [], "CellState": 0, "GateWeights": null,
+  "neighborhood": [], "update_rules": "sum", "nca_state": null}]`
+	for _, p := range LintNeuronConfig([]byte(config), false) {
+		t.Errorf("unexpected problem %s", p)
+	}
+}
@@ -1,50 +1,7 @@
 package main
 
-import "fmt"
+import "os"
 
 func main() {
-	/*fmt.Println("---SIMPLE---")
-	simple1()
-	fmt.Println("---Quantum---")
-	RunQuantumExample()
-	fmt.Println("---NeuroCellular Automata---")
-	testNeuroCellularAutomata()
-	fmt.Println("---testFullRangeOfNeuronsNCA---")
-	testFullRangeOfNeuronsNCA()
-	fmt.Println("---testNeuroCellularAutomataWithCNNKernels---")
-	testNeuroCellularAutomataWithCNNKernels()
-
-	fmt.Println("---testMutations---")
-	testMutations()
-	fmt.Println("---testMutationsWithMultipleTypes---")
-	testMutationsWithMultipleTypes()
-	fmt.Println("---testAllNeuronTypes---")
-	testAllNeuronTypes()
-
-	fmt.Println("-----------------")
-	fmt.Println("-----------------")
-	fmt.Println("-----------------")
-	fmt.Println("-----------------")
-	fmt.Println("---testBlueprintMethods---")
-	testBlueprintMethods()
-	*/
-
-	/*fmt.Println("---simpleNAS---")
-	//simpleNAS()
-	fmt.Println("---simpleNASWithoutCrossover---")
-	//simpleNASWithoutCrossover()
-
-	fmt.Println("---testWithRandomConnections---")
-	testWithRandomConnections()*/
-	/*testWithRandomConnections()
-	fmt.Println("---createAndSaveAllNeuronTypesToFile---")
-	createAndSaveAllNeuronTypesToFile("output/test.json")*/
-
-	//fmt.Println("---TestGpuRunBenchmark---")
-	//TestGpuRunBenchmark()
-
-	//fmt.Println("---TestRunBenchmark---")
-	//TestRunBenchmark()
-	fmt.Println("---simpleMnist---")
-	simpleMnist()
+	os.Exit(runCLI(os.Args[1:]))
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// classLogits reads the output of each class from a network's outputs;
+// outputNodes maps class index i to the output neuron outputNodes[i]. Classes
+// whose neuron produced no value get -Inf.
+func classLogits(values map[int]float64, outputNodes []int) []float64 {
+	logits := make([]float64, len(outputNodes))
+	for class, id := range outputNodes {
+		v, ok := values[id]
+		if !ok || math.IsNaN(v) {
+			v = math.Inf(-1)
+		}
+		logits[class] = v
+	}
+	return logits
+}
+
+// Softmax converts logits into probabilities. The largest logit is subtracted
+// first so large values cannot overflow; if every logit is -Inf the
+// probabilities are uniform.
+func Softmax(logits []float64) []float64 {
+	probs := LogSoftmax(logits)
+	for i, lp := range probs {
+		probs[i] = math.Exp(lp)
+	}
+	return probs
+}
+
+// LogSoftmax returns the log-probabilities of the logits, computed with the
+// log-sum-exp trick.
+func LogSoftmax(logits []float64) []float64 {
+	out := make([]float64, len(logits))
+	if len(logits) == 0 {
+		return out
+	}
+
+	maxLogit := math.Inf(-1)
+	for _, v := range logits {
+		if v > maxLogit {
+			maxLogit = v
+		}
+	}
+	if math.IsInf(maxLogit, -1) {
+		uniform := -math.Log(float64(len(logits)))
+		for i := range out {
+			out[i] = uniform
+		}
+		return out
+	}
+	if math.IsInf(maxLogit, 1) {
+		// Infinite logits share all the probability
+		n := 0
+		for _, v := range logits {
+			if math.IsInf(v, 1) {
+				n++
+			}
+		}
+		for i, v := range logits {
+			if math.IsInf(v, 1) {
+				out[i] = -math.Log(float64(n))
+			} else {
+				out[i] = math.Inf(-1)
+			}
+		}
+		return out
+	}
+
+	var sumExp float64
+	for _, v := range logits {
+		sumExp += math.Exp(v - maxLogit)
+	}
+	logSum := maxLogit + math.Log(sumExp)
+	for i, v := range logits {
+		out[i] = v - logSum
+	}
+	return out
+}
+
+// CrossEntropy returns the negative log-likelihood of the target class under
+// the softmax of the logits.
+func CrossEntropy(logits []float64, target int) float64 {
+	return -LogSoftmax(logits)[target]
+}
+
+// Argmax returns the index of the largest value, preferring the lower index
+// on ties. It returns 0 for an empty slice.
+func Argmax(values []float64) int {
+	best := 0
+	for i, v := range values {
+		if v > values[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// TopK returns the indices of the k largest values in descending order of
+// value, preferring lower indices on ties. k is clamped to [0, len(values)].
+func TopK(values []float64, k int) []int {
+	k = max(0, min(k, len(values)))
+	indices := make([]int, len(values))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return values[indices[a]] > values[indices[b]]
+	})
+	return indices[:k]
+}
+
+// argmaxClass returns the class whose output neuron has the largest value,
+// preferring the lower class index on ties.
+func argmaxClass(values map[int]float64, outputNodes []int) int {
+	return Argmax(classLogits(values, outputNodes))
+}
+
+// calibrationBins is the number of equal-width confidence bins used for the
+// expected calibration error.
+const calibrationBins = 10
+
+// CalibrationBin tallies the predictions whose confidence fell into one bin.
+type CalibrationBin struct {
+	Count         int
+	Correct       int
+	ConfidenceSum float64
+}
+
+// calibrationBin returns the bin a confidence in [0, 1] belongs to.
+func calibrationBin(confidence float64) int {
+	bin := int(confidence * calibrationBins)
+	if bin >= calibrationBins {
+		bin = calibrationBins - 1
+	}
+	if bin < 0 {
+		bin = 0
+	}
+	return bin
+}
+
+// expectedCalibrationError is the count-weighted mean gap between the
+// accuracy and the mean confidence of each bin.
+func expectedCalibrationError(bins []CalibrationBin) float64 {
+	total := 0
+	for _, b := range bins {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	var ece float64
+	for _, b := range bins {
+		if b.Count == 0 {
+			continue
+		}
+		accuracy := float64(b.Correct) / float64(b.Count)
+		confidence := b.ConfidenceSum / float64(b.Count)
+		ece += float64(b.Count) / float64(total) * math.Abs(accuracy-confidence)
+	}
+	return ece
+}
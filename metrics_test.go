@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// approxEqual reports whether two floats agree to 1e-9.
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSoftmax(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name   string
+		logits []float64
+		want   []float64
+	}{
+		{"empty", nil, []float64{}},
+		{"equal", []float64{2, 2}, []float64{0.5, 0.5}},
+		{"ordered", []float64{0, math.Log(3)}, []float64{0.25, 0.75}},
+		{"large values do not overflow", []float64{1000, 1000 + math.Log(3)}, []float64{0.25, 0.75}},
+		{"all -Inf is uniform", []float64{-inf, -inf, -inf, -inf}, []float64{0.25, 0.25, 0.25, 0.25}},
+		{"+Inf takes everything", []float64{inf, 5, inf}, []float64{0.5, 0, 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Softmax(tt.logits)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Softmax(%v) = %v, want %v", tt.logits, got, tt.want)
+			}
+			for i := range got {
+				if !approxEqual(got[i], tt.want[i]) {
+					t.Fatalf("Softmax(%v) = %v, want %v", tt.logits, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCrossEntropy(t *testing.T) {
+	tests := []struct {
+		logits []float64
+		target int
+		want   float64
+	}{
+		{[]float64{0, 0}, 0, math.Log(2)},
+		{[]float64{0, math.Log(3)}, 1, -math.Log(0.75)},
+		{[]float64{math.Inf(-1), 0}, 0, math.Inf(1)},
+	}
+	for _, tt := range tests {
+		if got := CrossEntropy(tt.logits, tt.target); !approxEqual(got, tt.want) && got != tt.want {
+			t.Errorf("CrossEntropy(%v, %d) = %g, want %g", tt.logits, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestArgmax(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   int
+	}{
+		{nil, 0},
+		{[]float64{1, 3, 2}, 1},
+		{[]float64{3, 1, 3}, 0}, // ties go to the lower index
+		{[]float64{math.Inf(-1), -5}, 1},
+	}
+	for _, tt := range tests {
+		if got := Argmax(tt.values); got != tt.want {
+			t.Errorf("Argmax(%v) = %d, want %d", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestTopK(t *testing.T) {
+	values := []float64{0.1, 0.4, 0.2, 0.4}
+	tests := []struct {
+		k    int
+		want []int
+	}{
+		{-1, []int{}},
+		{0, []int{}},
+		{1, []int{1}},
+		{3, []int{1, 3, 2}}, // ties keep the lower index first
+		{4, []int{1, 3, 2, 0}},
+		{10, []int{1, 3, 2, 0}}, // clamped to the number of values
+	}
+	for _, tt := range tests {
+		if got := TopK(values, tt.k); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopK(%v, %d) = %v, want %v", values, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestClassLogits(t *testing.T) {
+	values := map[int]float64{10: 1, 11: math.NaN()}
+	got := classLogits(values, []int{10, 11, 12})
+	want := []float64{1, math.Inf(-1), math.Inf(-1)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("classLogits = %v, want %v", got, want)
+	}
+}
+
+func TestExpectedCalibrationError(t *testing.T) {
+	tests := []struct {
+		name string
+		bins []CalibrationBin
+		want float64
+	}{
+		{"empty", make([]CalibrationBin, calibrationBins), 0},
+		{"calibrated", []CalibrationBin{{Count: 10, Correct: 9, ConfidenceSum: 9}}, 0},
+		{"overconfident", []CalibrationBin{{Count: 4, Correct: 2, ConfidenceSum: 3.6}}, 0.4},
+		{
+			"weighted by count",
+			[]CalibrationBin{
+				{Count: 1, Correct: 1, ConfidenceSum: 0.5}, // gap 0.5
+				{Count: 3, Correct: 0, ConfidenceSum: 0.3}, // gap 0.1
+				{Count: 0, Correct: 0, ConfidenceSum: 0},   // skipped
+			},
+			0.25*0.5 + 0.75*0.1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expectedCalibrationError(tt.bins); !approxEqual(got, tt.want) {
+				t.Errorf("expectedCalibrationError = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalibrationBin(t *testing.T) {
+	tests := []struct {
+		confidence float64
+		want       int
+	}{
+		{-0.1, 0},
+		{0, 0},
+		{0.15, 1},
+		{0.999, 9},
+		{1, 9},
+	}
+	for _, tt := range tests {
+		if got := calibrationBin(tt.confidence); got != tt.want {
+			t.Errorf("calibrationBin(%g) = %d, want %d", tt.confidence, got, tt.want)
+		}
+	}
+}
+
+func TestClassificationReport(t *testing.T) {
+	// Three classes; each prediction is a one-hot logit vector scaled up so
+	// the softmax is confident
+	predictions := []struct {
+		predicted, expected int
+	}{
+		{0, 0}, {0, 0}, {1, 0},
+		{1, 1},
+		{0, 2}, {2, 2},
+	}
+	r := NewClassificationReport(3, 2)
+	for _, p := range predictions {
+		logits := make([]float64, 3)
+		logits[p.predicted] = 50
+		r.Add(logits, p.expected)
+	}
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"accuracy", r.Accuracy(), 4.0 / 6},
+		{"precision 0", r.Precision(0), 2.0 / 3},
+		{"recall 0", r.Recall(0), 2.0 / 3},
+		{"F1 0", r.F1(0), 2.0 / 3},
+		{"precision 1", r.Precision(1), 0.5},
+		{"recall 1", r.Recall(1), 1},
+		{"F1 1", r.F1(1), 2.0 / 3},
+		{"F1 2", r.F1(2), 2.0 / 3},
+		{"macro F1", r.MacroF1(), 2.0 / 3},
+		// Every prediction is made with confidence ~1, so the gap is the error rate
+		{"ECE", r.ECE(), 2.0 / 6},
+	}
+	for _, tt := range tests {
+		if !approxEqual(tt.got, tt.want) {
+			t.Errorf("%s = %g, want %g", tt.name, tt.got, tt.want)
+		}
+	}
+	if want := [][]int{{2, 1, 0}, {0, 1, 0}, {1, 0, 1}}; !reflect.DeepEqual(r.Confusion, want) {
+		t.Errorf("confusion = %v, want %v", r.Confusion, want)
+	}
+	// With k = 2, the runner-up of a one-hot vector is the lowest other index
+	if r.TopKCorrect != 5 {
+		t.Errorf("top-2 correct = %d, want 5", r.TopKCorrect)
+	}
+}
+
+func TestF1WithoutPredictions(t *testing.T) {
+	r := NewClassificationReport(2, 1)
+	r.Add([]float64{1, 0}, 0)
+	if got := r.F1(1); got != 0 {
+		t.Errorf("F1 of a class never expected or predicted = %g, want 0", got)
+	}
+}
+
+func TestRegressionReport(t *testing.T) {
+	outputs := []int{5, 6}
+	expected := []map[int]float64{{5: 1, 6: 0}, {5: 3, 6: 2}}
+	means := meanOutputs(expected, outputs)
+	if !reflect.DeepEqual(means, []float64{2, 1}) {
+		t.Fatalf("meanOutputs = %v, want [2 1]", means)
+	}
+
+	r := NewRegressionReport(outputs, means)
+	r.Add(map[int]float64{5: 1, 6: 1}, expected[0]) // errors 0 and 1
+	r.Add(map[int]float64{5: 3, 6: 2}, expected[1]) // no error
+	if r.Total != 2 || !approxEqual(r.MSE, 0.25) || !approxEqual(r.BaselineMSE, 1) {
+		t.Errorf("got %d sessions, MSE %g, baseline %g; want 2, 0.25, 1", r.Total, r.MSE, r.BaselineMSE)
+	}
+}
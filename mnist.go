@@ -1,137 +1,222 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
 	"image/png"
+	"io"
 	"log"
-	"math"
-	"math/rand"
 	"os"
 	"path/filepath"
-	"time"
 
 	"blueprint"
 )
 
-const (
-	baseURL   = "https://storage.googleapis.com/cvdf-datasets/mnist/"
-	mnistDir  = "mnist"
-	modelDir  = "models" // New directory for saving models
-	modelName = "mnist_model.json"
-)
+// modelDir is the directory inside a dataset's directory that models are saved to.
+const modelDir = "models"
 
-func simpleMnist() {
-	bp := blueprint.NewBlueprint()
+// runImageDataset downloads an image dataset, trains a network on it as
+// described by the run's experiment and evaluates it on the test split.
+func runImageDataset(rc *RunContext, run *TrainingRun, ds ImageDataset) error {
+	exp := run.Experiment
+	bp := rc.NewBlueprint()
 
-	// Ensure MNIST data is downloaded and unzipped
-	if err := EnsureMNISTDownloads(bp, mnistDir); err != nil {
-		log.Fatalf("Failed to ensure MNIST downloads: %v", err)
+	var synthetic DatasetFetcher = syntheticMNISTFetcher{seed: syntheticMNISTSeed, dataset: ds}
+	if ds.Format == formatCIFAR {
+		synthetic = syntheticCIFARFetcher{seed: syntheticMNISTSeed}
+	}
+	fetcher, verify, err := resolveDatasetSource(bp, exp.Dataset.Source, ds.BaseURL, synthetic)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ds.Name, err)
 	}
 
-	// Process the MNIST data
-	imageFile := filepath.Join(mnistDir, "train-images-idx3-ubyte")
-	labelFile := filepath.Join(mnistDir, "train-labels-idx1-ubyte")
-	outputDir := filepath.Join(mnistDir, "output")
+	// Keep generated data apart from the real dataset
+	dataDir := ds.Dir
+	if _, ok := fetcher.(syntheticFetcher); ok {
+		dataDir = filepath.Join(ds.Dir, syntheticSource)
+	}
 
-	if err := UnpackMNIST(imageFile, labelFile, outputDir); err != nil {
-		log.Fatalf("Failed to unpack MNIST data: %v", err)
+	// Ensure the dataset is downloaded and unzipped. Downloads, loading and
+	// evaluation cannot be interrupted themselves, so an interrupt abandons
+	// them like a search round.
+	err = runCancellableErr(rc.Context, func() error {
+		return EnsureImageDataset(bp, ds, fetcher, verify, dataDir)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ensure %s downloads: %w", ds.Name, err)
 	}
 
-	// Train the model
-	if err := TrainOnMNIST(bp, outputDir); err != nil {
-		log.Fatalf("Failed to train on MNIST data: %v", err)
+	if run.Stage == stageSearch || run.Stage == stagePostProcessing {
+		var train *DenseDataset
+		err := runCancellableErr(rc.Context, func() error {
+			// Optionally write the images out as PNG files for inspection
+			if exp.Dataset.ExportPNG {
+				outputDir := filepath.Join(dataDir, "output")
+				if err := unpackImageSplit(ds, dataDir, outputDir); err != nil {
+					return fmt.Errorf("failed to unpack %s data: %w", ds.Name, err)
+				}
+			}
+
+			// Load the training set straight from the downloaded files
+			var err error
+			if train, err = loadImageSplit(ds, dataDir, false, exp.Dataset.Grayscale); err != nil {
+				return fmt.Errorf("failed to load %s data: %w", ds.Name, err)
+			}
+			if train, err = preprocessTraining(run, train); err != nil {
+				return fmt.Errorf("failed to preprocess %s data: %w", ds.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Train the model
+		run.Metadata.ClassNames = ds.ClassNames
+		if err := TrainOnImageDataset(rc.Context, bp, train, run); err != nil {
+			return fmt.Errorf("failed to train on %s data: %w", ds.Name, err)
+		}
+	} else if err := run.RestoreInto(bp); err != nil {
+		return err
 	}
-}
 
-// EnsureMNISTDownloads downloads and unzips the MNIST dataset.
-func EnsureMNISTDownloads(bp *blueprint.Blueprint, targetDir string) error {
-	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+	// Evaluate on the held-out test split
+	err = runCancellableErr(rc.Context, func() error {
+		test, err := loadImageSplit(ds, dataDir, true, exp.Dataset.Grayscale)
+		if err != nil {
+			return fmt.Errorf("failed to load %s test data: %w", ds.Name, err)
+		}
+		if test, err = preprocessHeldOut(run, test); err != nil {
+			return fmt.Errorf("failed to preprocess %s test data: %w", ds.Name, err)
+		}
+		EvaluateImageDataset(bp, test, ds.ClassNames)
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	return run.Advance(bp, stageDone)
+}
 
-	files := []string{
-		"train-images-idx3-ubyte.gz",
-		"train-labels-idx1-ubyte.gz",
-		"t10k-images-idx3-ubyte.gz",
-		"t10k-labels-idx1-ubyte.gz",
+// loadImageSplit loads the training or test split of a downloaded dataset.
+// grayscale only affects colour datasets.
+func loadImageSplit(ds ImageDataset, dataDir string, test, grayscale bool) (*DenseDataset, error) {
+	if ds.Format == formatCIFAR {
+		batches := cifarTrainBatches
+		if test {
+			batches = cifarTestBatches
+		}
+		files := make([]string, len(batches))
+		for i, batch := range batches {
+			files[i] = filepath.Join(dataDir, cifarBatchDir, batch)
+		}
+		return LoadCIFAR10(ds, files, grayscale)
 	}
 
-	for _, file := range files {
+	images, labels := ds.TrainImages, ds.TrainLabels
+	if test {
+		images, labels = ds.TestImages, ds.TestLabels
+	}
+	return LoadImageDataset(ds,
+		filepath.Join(dataDir, unzipped(images)),
+		filepath.Join(dataDir, unzipped(labels)),
+	)
+}
+
+// unpackImageSplit writes the training images of a downloaded dataset out as PNG files.
+func unpackImageSplit(ds ImageDataset, dataDir, outputDir string) error {
+	if ds.Format == formatCIFAR {
+		files := make([]string, len(cifarTrainBatches))
+		for i, batch := range cifarTrainBatches {
+			files[i] = filepath.Join(dataDir, cifarBatchDir, batch)
+		}
+		return UnpackCIFAR10(files, outputDir)
+	}
+	return UnpackMNIST(
+		filepath.Join(dataDir, unzipped(ds.TrainImages)),
+		filepath.Join(dataDir, unzipped(ds.TrainLabels)),
+		outputDir,
+	)
+}
+
+// EnsureImageDataset fetches and unpacks the archives of the dataset. When
+// verify is set, each archive with a published checksum must match it before
+// it is unpacked.
+func EnsureImageDataset(bp *blueprint.Blueprint, ds ImageDataset, fetcher DatasetFetcher, verify bool, targetDir string) error {
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	for _, file := range ds.Archives() {
 		localFile := filepath.Join(targetDir, file)
 		if _, err := os.Stat(localFile); os.IsNotExist(err) {
-			log.Printf("Downloading %s...\n", file)
-			if err := bp.DownloadFile(localFile, baseURL+file); err != nil {
-				return err
+			log.Printf("Fetching %s...\n", file)
+			if err := fetcher.Fetch(file, localFile); err != nil {
+				return fmt.Errorf("failed to fetch %s: %w", file, err)
 			}
-			log.Printf("Downloaded %s\n", file)
+			log.Printf("Fetched %s\n", file)
+		} else {
+			log.Printf("%s already exists, skipping download.\n", file)
+		}
 
-			if err := bp.UnzipFile(localFile, targetDir); err != nil {
+		// Unpack only when the extracted files are missing
+		extracted := filepath.Join(targetDir, unzipped(file))
+		if ds.Format == formatCIFAR {
+			extracted = filepath.Join(targetDir, cifarBatchDir)
+		}
+		if _, err := os.Stat(extracted); err == nil {
+			continue
+		}
+		if checksum, ok := ds.Checksums[file]; verify && ok {
+			if err := verifyChecksum(localFile, checksum); err != nil {
 				return err
 			}
+		} else if verify {
+			log.Printf("No published checksum for %s, skipping verification.", file)
+		}
+
+		var err error
+		if ds.Format == formatCIFAR {
+			err = extractTarGz(localFile, targetDir)
 		} else {
-			log.Printf("%s already exists, skipping download.\n", file)
+			err = bp.UnzipFile(localFile, targetDir)
+		}
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// UnpackMNIST processes MNIST data into images and labels.
+// UnpackMNIST writes the MNIST images as PNG files plus a labels.json map.
+// It is only needed to inspect the data; training reads the IDX files directly.
 func UnpackMNIST(imageFile, labelFile, outputDir string) error {
-	imgFile, err := os.Open(imageFile)
-	if err != nil {
-		return fmt.Errorf("failed to open image file: %w", err)
-	}
-	defer imgFile.Close()
-
-	lblFile, err := os.Open(labelFile)
+	reader, err := openIDX(imageFile, labelFile)
 	if err != nil {
-		return fmt.Errorf("failed to open label file: %w", err)
-	}
-	defer lblFile.Close()
-
-	var imgHeader [16]byte
-	if _, err := imgFile.Read(imgHeader[:]); err != nil {
-		return fmt.Errorf("failed to read image header: %w", err)
-	}
-
-	var lblHeader [8]byte
-	if _, err := lblFile.Read(lblHeader[:]); err != nil {
-		return fmt.Errorf("failed to read label header: %w", err)
-	}
-
-	numImages := binary.BigEndian.Uint32(imgHeader[4:8])
-	imgRows := binary.BigEndian.Uint32(imgHeader[8:12])
-	imgCols := binary.BigEndian.Uint32(imgHeader[12:16])
-
-	numLabels := binary.BigEndian.Uint32(lblHeader[4:8])
-	if numImages != numLabels {
-		return fmt.Errorf("image and label count mismatch: %d images, %d labels", numImages, numLabels)
+		return err
 	}
+	defer reader.Close()
 
-	log.Printf("Processing %d images (%dx%d)...", numImages, imgRows, imgCols)
+	log.Printf("Processing %d images (%dx%d)...", reader.Count, reader.Rows, reader.Cols)
 
 	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
 		return err
 	}
 
 	labelMap := make(map[string]int)
-	imgSize := int(imgRows * imgCols)
 
-	for i := 0; i < int(numImages); i++ {
-		imgData := make([]byte, imgSize)
-		if _, err := imgFile.Read(imgData); err != nil {
-			return fmt.Errorf("failed to read image %d: %w", i, err)
+	for i := 0; ; i++ {
+		imgData, label, err := reader.Next()
+		if err == io.EOF {
+			break
 		}
-
-		var label byte
-		if err := binary.Read(lblFile, binary.BigEndian, &label); err != nil {
-			return fmt.Errorf("failed to read label %d: %w", i, err)
+		if err != nil {
+			return err
 		}
 
-		img := image.NewGray(image.Rect(0, 0, int(imgCols), int(imgRows)))
+		img := image.NewGray(image.Rect(0, 0, reader.Cols, reader.Rows))
 		copy(img.Pix, imgData)
 
 		imgFilename := fmt.Sprintf("img_%05d.png", i)
@@ -148,10 +233,10 @@ func UnpackMNIST(imageFile, labelFile, outputDir string) error {
 		}
 		imgOut.Close()
 
-		labelMap[imgFilename] = int(label)
+		labelMap[imgFilename] = label
 
 		if i%1000 == 0 {
-			log.Printf("Processed %d/%d images...", i, numImages)
+			log.Printf("Processed %d/%d images...", i, reader.Count)
 		}
 	}
 
@@ -172,203 +257,94 @@ func UnpackMNIST(imageFile, labelFile, outputDir string) error {
 	return nil
 }
 
-// TrainOnMNIST trains the neural network using the MNIST dataset.
-func TrainOnMNIST(bp *blueprint.Blueprint, mnistOutputDir string) error {
-	rand.Seed(time.Now().UnixNano())
-
-	// Load the label map
-	labelMapPath := filepath.Join(mnistOutputDir, "labels.json")
-	labelMapFile, err := os.Open(labelMapPath)
+// LoadImageDataset streams an IDX image/label pair of the dataset into a
+// dense dataset with normalised pixel inputs on neurons 1..rows*cols and
+// one-hot expected outputs on ds.OutputNodes().
+func LoadImageDataset(ds ImageDataset, imageFile, labelFile string) (*DenseDataset, error) {
+	reader, err := openIDX(imageFile, labelFile)
 	if err != nil {
-		return fmt.Errorf("failed to open label map file: %w", err)
+		return nil, err
 	}
-	defer labelMapFile.Close()
+	defer reader.Close()
 
-	var labelMap map[string]int
-	if err := json.NewDecoder(labelMapFile).Decode(&labelMap); err != nil {
-		return fmt.Errorf("failed to decode label map: %w", err)
-	}
+	log.Printf("Loading %d images (%dx%d)...", reader.Count, reader.Rows, reader.Cols)
 
-	// Create sessions
-	var sessions []blueprint.Session
-	var inputSize int
-	for imgFilename, label := range labelMap {
-		imgPath := filepath.Join(mnistOutputDir, imgFilename)
-		imgFile, err := os.Open(imgPath)
-		if err != nil {
-			return fmt.Errorf("failed to open image file %s: %w", imgPath, err)
+	inputSize := reader.Rows * reader.Cols
+	inputNodes := make([]int, inputSize)
+	for i := range inputNodes {
+		inputNodes[i] = i + 1
+	}
+	outputNodes := ds.OutputNodes()
+	data := NewDenseDataset(inputNodes, outputNodes, reader.Count)
+	data.Image = &ImageShape{Width: reader.Cols, Height: reader.Rows, Channels: 1}
+
+	inputs := make([]float32, inputSize)
+	outputs := make([]float32, len(outputNodes))
+	for {
+		pixels, label, err := reader.Next()
+		if err == io.EOF {
+			break
 		}
-
-		img, err := png.Decode(imgFile)
-		imgFile.Close()
 		if err != nil {
-			return fmt.Errorf("failed to decode image %s: %w", imgPath, err)
+			return nil, err
 		}
-
-		grayImg := img.(*image.Gray)
-		inputVars := make(map[int]float64)
-		for i, pixel := range grayImg.Pix {
-			inputVars[i+1] = float64(pixel) / 255.0 // Normalize [0, 1]
+		class := label - ds.LabelOffset
+		if class < 0 || class >= len(outputNodes) {
+			return nil, fmt.Errorf("label %d out of range in %s", label, labelFile)
 		}
-		inputSize = len(grayImg.Pix) // 784 for MNIST
 
-		// One-hot expected output
-		expectedOutput := make(map[int]float64)
-		for digit := 0; digit < 10; digit++ {
-			outNodeID := 80001 + digit
-			if digit == label {
-				expectedOutput[outNodeID] = 1.0
-			} else {
-				expectedOutput[outNodeID] = 0.0
+		for i, pixel := range pixels {
+			j := i
+			if ds.Transposed {
+				// Stored column by column; feed the inputs row by row
+				j = (i%reader.Rows)*reader.Cols + i/reader.Rows
 			}
+			inputs[j] = float32(pixel) / 255.0 // Normalize [0, 1]
 		}
-
-		sessions = append(sessions, blueprint.Session{
-			InputVariables: inputVars,
-			ExpectedOutput: expectedOutput,
-			Timesteps:      1,
-		})
-	}
-
-	// Define input and output nodes
-	inputNodes := make([]int, inputSize)
-	for i := 0; i < inputSize; i++ {
-		inputNodes[i] = i + 1
-	}
-	outputNodes := []int{80001, 80002, 80003, 80004, 80005, 80006, 80007, 80008, 80009, 80010}
-
-	bp.AddInputNodes(inputNodes)
-	bp.AddOutputNodes(outputNodes)
-
-	// Initialize input neurons
-	for _, id := range inputNodes {
-		bp.Neurons[id] = &blueprint.Neuron{
-			ID:   id,
-			Type: "input",
-		}
-	}
-
-	// Initialize output neurons as linear
-	for _, outID := range outputNodes {
-		bp.Neurons[outID] = &blueprint.Neuron{
-			ID:          outID,
-			Type:        "output",
-			Activation:  "linear",
-			Connections: [][]float64{},
+		// One-hot expected output
+		for c := range outputs {
+			outputs[c] = 0
 		}
+		outputs[class] = 1
+		data.Append(inputs, outputs)
 	}
+	return data, nil
+}
 
-	// Parameters for NAS
-	maxIterations := 10000 // Adjust as needed
-
-	neuronTypes := []string{"dense", "rnn", "cnn", "dropout", "attention"}
-	weightUpdateIterations := 10
-	maxTriesWithoutImprovement := 10
-	batches := 10
-
-	useHillClimbing := true        // Disable hill climbing
-	saveImprovedModel := true      // Enable saving improved models
-	saveLocation := "mnist/models" // Directory to save the models
-
-	fmt.Println("Training the model with AdvancedParallelNASWithDynamicNeuronGeneration...")
-	// Run the NAS function
-	bp.AdvancedParallelNASWithDynamicNeuronGeneration(
-		sessions,
-		maxIterations,
-		neuronTypes,
-		weightUpdateIterations,
-		useHillClimbing,
-		saveImprovedModel,
-		saveLocation,
-		maxTriesWithoutImprovement,
-		batches,
-	)
-	// Initialize PerformanceLogger
-	logDir := filepath.Join(mnistDir, "log")
-	logger, err := blueprint.NewPerformanceLogger(logDir)
-	if err != nil {
-		log.Fatalf("Failed to initialize PerformanceLogger: %v", err)
-	}
-
-	// Evaluate and log performance for all sessions
-	if err := bp.EvaluateAndLogPerformance(sessions, logger); err != nil {
-		log.Fatalf("Failed to evaluate and log performance: %v", err)
+// TrainOnImageDataset sets up the input and output neurons of an image dataset
+// and trains the network on it as described by the run's experiment.
+func TrainOnImageDataset(ctx context.Context, bp *blueprint.Blueprint, data *DenseDataset, run *TrainingRun) error {
+	if data.Len() == 0 {
+		return fmt.Errorf("no training sessions")
 	}
+	setupIONeurons(bp, data.InputIDs, data.OutputIDs)
 
-	log.Println("Performance evaluation and logging completed successfully.")
-
-	// After main training, try the targeted micro-refinement
-	fmt.Println("Applying Targeted Micro Refinement...")
-	bp.TargetedMicroRefinement(
-		sessions,
-		50,   // max refinement iterations
-		20,   // sampleSubsetSize
-		10,   // connectionTrialsPerSample
-		30.0, // improvementThreshold for exact accuracy
-	)
-
-	// After refinement, try adding new connections using the improved multithreaded method
-	/*fmt.Println("Trying to add new connections to improve accuracy...")
-	bp.TryAddConnections(sessions, 50) // up to 50 unique connection attempts
+	return TrainWithExperiment(ctx, bp, data, data.OutputIDs, run)
+}
 
-	// Perform learning by processing one data item at a time
-	fmt.Println("\nStarting LearnOneDataItemAtATime phase...")
-	bp.LearnOneDataItemAtATime(sessions, 10, neuronTypes, 5) // Adjust maxAttemptsPerSession as needed
-	*/
+// EvaluateImageDataset reports how well the trained model classifies the held-out
+// test sessions of an image dataset with the given class names.
+func EvaluateImageDataset(bp *blueprint.Blueprint, test *DenseDataset, classNames []string) *ClassificationReport {
+	outputNodes := test.OutputIDs
 
-	// Test the final model on a few samples
+	// Show the raw predictions for a few samples
+	samples := sessionBatch(test, 0, 10)
 	fmt.Println("\nTesting the final model (raw predictions):")
-	for i, session := range sessions[:10] {
+	for i, session := range samples {
 		bp.RunNetwork(session.InputVariables, session.Timesteps)
-		predictedOutput := bp.GetOutputs()
+		logits := classLogits(bp.GetOutputs(), outputNodes)
 
 		// Apply softmax
-		probs := softmaxMap(predictedOutput)
-		predClass := argmaxMap(probs)
-		expClass := argmaxMap(session.ExpectedOutput)
+		probs := Softmax(logits)
+		predClass := Argmax(logits)
+		expClass := argmaxClass(session.ExpectedOutput, outputNodes)
 
-		fmt.Printf("Test %d: Expected: %d, Predicted: %d, Probabilities: %v\n", i+1, expClass, predClass, probs)
+		fmt.Printf("Test %d: Expected: %s, Predicted: %s, Probabilities: %.4f\n", i+1, classNames[expClass], classNames[predClass], probs)
 	}
 
-	// Ensure the models directory exists
-	completeModelDir := filepath.Join(mnistDir, modelDir)
-	if err := os.MkdirAll(completeModelDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create models directory: %w", err)
-	}
-
-	// Save the model to mnist/models/mnist_model.json
-	modelPath := filepath.Join(completeModelDir, modelName)
-	if err := bp.SaveToJSON(modelPath); err != nil {
-		return fmt.Errorf("failed to save model: %w", err)
-	}
-
-	fmt.Printf("\nTraining complete. Model saved to %s\n", modelPath)
-	return nil
-}
-
-// softmaxMap applies softmax to the values in a map and returns a new map with probabilities.
-func softmaxMap(m map[int]float64) map[int]float64 {
-	var sumExp float64
-	for _, v := range m {
-		sumExp += math.Exp(v)
-	}
-	probs := make(map[int]float64)
-	for k, v := range m {
-		probs[k] = math.Exp(v) / sumExp
-	}
-	return probs
-}
-
-// argmaxMap returns the key of the maximum value in the map.
-func argmaxMap(m map[int]float64) int {
-	var maxKey int
-	var maxVal float64 = -math.MaxFloat64
-	for k, v := range m {
-		if v > maxVal {
-			maxVal = v
-			maxKey = k
-		}
-	}
-	// Convert from neuron ID to class index (0-9)
-	return maxKey - 80001
+	fmt.Printf("\nEvaluating on %d held-out test samples...\n", test.Len())
+	report := EvaluateClassifier(bp, test, outputNodes)
+	report.ClassNames = classNames
+	report.Print(os.Stdout)
+	return report
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"blueprint"
+)
+
+// modelMetadataKey is the top-level key of the model JSON that holds the
+// harness metadata next to the blueprint's own fields.
+const modelMetadataKey = "hammer"
+
+// ModelMetadata records how a saved model was produced.
+type ModelMetadata struct {
+	Scenario   string `json:"scenario,omitempty"`
+	Experiment string `json:"experiment,omitempty"`
+	Seed       int64  `json:"seed"`
+	// Preprocessing is the fitted pipeline the model's inputs go through.
+	Preprocessing *Pipeline `json:"preprocessing,omitempty"`
+	// Tabular is set for models trained on a tabular dataset and encodes
+	// rows of its file into raw inputs.
+	Tabular *TabularEncoding `json:"tabular,omitempty"`
+
+	// What inference needs to feed the model and read its outputs.
+	InputNodes  []int `json:"input_nodes,omitempty"`
+	OutputNodes []int `json:"output_nodes,omitempty"`
+	Timesteps   int   `json:"timesteps,omitempty"`
+	// SequenceWindow is set for models trained on a sequence dataset, which
+	// are fed one step per RunNetwork call (see RunSequence).
+	SequenceWindow int      `json:"sequence_window,omitempty"`
+	ClassOutputs   []int    `json:"class_outputs,omitempty"` // set for classifiers, in class order
+	ClassNames     []string `json:"class_names,omitempty"`
+}
+
+// SaveModelJSON writes the blueprint to path like bp.SaveToJSON does and adds
+// the run metadata under the "hammer" key. The file is replaced atomically.
+func SaveModelJSON(bp *blueprint.Blueprint, path string, meta ModelMetadata) error {
+	jsonStr, err := bp.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
+	}
+	return saveModelSnapshot(jsonStr, path, meta)
+}
+
+// saveModelSnapshot is SaveModelJSON for a blueprint already serialised by bp.ToJSON.
+func saveModelSnapshot(jsonStr, path string, meta ModelMetadata) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonStr), &fields); err != nil {
+		return fmt.Errorf("failed to decode blueprint JSON: %w", err)
+	}
+
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode model metadata: %w", err)
+	}
+	fields[modelMetadataKey] = rawMeta
+
+	data, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode model: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write model file: %w", err)
+	}
+	return nil
+}
+
+// Model is a saved network together with the metadata it was saved with.
+type Model struct {
+	Blueprint *blueprint.Blueprint
+	Metadata  ModelMetadata
+}
+
+// LoadModelJSON reads a model written by SaveModelJSON, or a plain
+// bp.SaveToJSON file, which has no metadata.
+func LoadModelJSON(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode model %s: %w", path, err)
+	}
+	model := &Model{Blueprint: blueprint.NewBlueprint()}
+	if raw, ok := fields[modelMetadataKey]; ok {
+		if err := json.Unmarshal(raw, &model.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode model metadata in %s: %w", path, err)
+		}
+	}
+	if err := RestoreBlueprint(model.Blueprint, string(data)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	model.restoreNodeLists()
+	return model, nil
+}
+
+// restoreNodeLists falls back on the recorded node lists if the blueprint
+// JSON lacks them.
+func (m *Model) restoreNodeLists() {
+	bp := m.Blueprint
+	if len(bp.InputNodes) == 0 {
+		bp.AddInputNodes(m.Metadata.InputNodes)
+	}
+	if len(bp.OutputNodes) == 0 {
+		bp.AddOutputNodes(m.Metadata.OutputNodes)
+	}
+}
+
+// Clone returns an independent copy of the model, so that copies can run
+// concurrently; a Blueprint keeps its activations between RunNetwork and
+// GetOutputs and must not be shared.
+func (m *Model) Clone() (*Model, error) {
+	jsonStr, err := m.Blueprint.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert blueprint to JSON: %w", err)
+	}
+	clone := &Model{Blueprint: blueprint.NewBlueprint(), Metadata: m.Metadata}
+	if err := RestoreBlueprint(clone.Blueprint, jsonStr); err != nil {
+		return nil, err
+	}
+	clone.restoreNodeLists()
+	return clone, nil
+}
+
+// InputIDs returns the raw inputs the model reads, before preprocessing, in
+// ascending order.
+func (m *Model) InputIDs() []int {
+	ids := m.Blueprint.InputNodes
+	if p := m.Metadata.Preprocessing; p != nil {
+		ids = p.InputIDs()
+	}
+	ids = append([]int(nil), ids...)
+	sort.Ints(ids)
+	return ids
+}
+
+// Run feeds raw inputs through the model's preprocessing and network and
+// returns the network's outputs.
+func (m *Model) Run(inputs map[int]float64, timesteps int) map[int]float64 {
+	if p := m.Metadata.Preprocessing; p != nil {
+		inputs = p.TransformInputs(inputs)
+	}
+	m.Blueprint.RunNetwork(inputs, timesteps)
+	return m.Blueprint.GetOutputs()
+}
+
+// RunSequence feeds the raw inputs of each step through the model's
+// preprocessing and, one RunNetwork call per step, the network, and returns
+// the outputs after the last step.
+func (m *Model) RunSequence(steps []map[int]float64) map[int]float64 {
+	if p := m.Metadata.Preprocessing; p != nil {
+		transformed := make([]map[int]float64, len(steps))
+		for t, step := range steps {
+			transformed[t] = p.TransformInputs(step)
+		}
+		steps = transformed
+	}
+	return RunSequence(m.Blueprint, steps)
+}
+
+// EncodeRow turns the feature cells of a row of the file a tabular model was
+// trained on, keyed by column name, into raw inputs for Run.
+func (m *Model) EncodeRow(cells map[string]string) (map[int]float64, error) {
+	if m.Metadata.Tabular == nil {
+		return nil, fmt.Errorf("the model was not trained on a tabular dataset")
+	}
+	return m.Metadata.Tabular.Encode(cells)
+}
+
+// RestoreBlueprint replaces the network in bp with one serialised by bp.ToJSON.
+func RestoreBlueprint(bp *blueprint.Blueprint, jsonStr string) error {
+	bp.Neurons = make(map[int]*blueprint.Neuron)
+	bp.QuantumNeurons = make(map[int]*blueprint.QuantumNeuron)
+	if err := json.Unmarshal([]byte(jsonStr), bp); err != nil {
+		return fmt.Errorf("failed to restore blueprint: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // register the JPEG decoder for image inputs
+	_ "image/png"  // register the PNG decoder for image inputs
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PredictionInput is one set of raw inputs to run a model on. Sequence
+// models take a window in Steps, fed one step per RunNetwork call.
+type PredictionInput struct {
+	Name      string            `json:"name,omitempty"`
+	Inputs    map[int]float64   `json:"inputs,omitempty"`
+	Steps     []map[int]float64 `json:"steps,omitempty"`
+	Timesteps int               `json:"timesteps,omitempty"`
+}
+
+// Prediction is what a model made of one input. Classifiers also report the
+// predicted class and the softmax probability of every class.
+type Prediction struct {
+	Input         string             `json:"input,omitempty"`
+	Outputs       map[int]float64    `json:"outputs"`
+	Class         string             `json:"class,omitempty"`
+	ClassIndex    *int               `json:"class_index,omitempty"`
+	Probabilities map[string]float64 `json:"probabilities,omitempty"`
+
+	ranked []string // class names by falling probability
+}
+
+// Predict runs the model on one input. A timesteps of 0 uses the input's own
+// value, then the one the model was trained with, then 1. Inputs with steps,
+// and any input to a sequence model, are run with RunSequence instead.
+func (m *Model) Predict(in PredictionInput, timesteps int) Prediction {
+	if in.Timesteps > 0 {
+		timesteps = in.Timesteps
+	}
+	if timesteps <= 0 {
+		timesteps = max(m.Metadata.Timesteps, 1)
+	}
+
+	var outputs map[int]float64
+	switch {
+	case len(in.Steps) > 0:
+		outputs = m.RunSequence(in.Steps)
+	case m.Metadata.SequenceWindow > 0:
+		outputs = m.RunSequence([]map[int]float64{in.Inputs})
+	default:
+		outputs = m.Run(in.Inputs, timesteps)
+	}
+	p := Prediction{Input: in.Name, Outputs: outputs}
+	if classOutputs := m.Metadata.ClassOutputs; classOutputs != nil {
+		logits := classLogits(outputs, classOutputs)
+		probs := Softmax(logits)
+		class := Argmax(logits)
+		p.ClassIndex = &class
+		p.Class = m.className(class)
+		p.Probabilities = make(map[string]float64, len(probs))
+		for class, prob := range probs {
+			p.Probabilities[m.className(class)] = prob
+		}
+		for _, class := range TopK(logits, len(logits)) {
+			p.ranked = append(p.ranked, m.className(class))
+		}
+	}
+	return p
+}
+
+// MarshalJSON writes non-finite outputs and probabilities as null, as JSON
+// has no number for them.
+func (p Prediction) MarshalJSON() ([]byte, error) {
+	type prediction Prediction // without this method
+	out := struct {
+		prediction
+		Outputs       map[int]*float64    `json:"outputs"`
+		Probabilities map[string]*float64 `json:"probabilities,omitempty"`
+	}{prediction: prediction(p), Outputs: make(map[int]*float64, len(p.Outputs))}
+	for id, v := range p.Outputs {
+		out.Outputs[id] = finiteOrNil(v)
+	}
+	if p.Probabilities != nil {
+		out.Probabilities = make(map[string]*float64, len(p.Probabilities))
+		for class, v := range p.Probabilities {
+			out.Probabilities[class] = finiteOrNil(v)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// finiteOrNil returns a pointer to v, or nil if v is NaN or infinite.
+func finiteOrNil(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// className returns the name of a class, or its index if it has none.
+func (m *Model) className(class int) string {
+	if class < len(m.Metadata.ClassNames) {
+		return m.Metadata.ClassNames[class]
+	}
+	return strconv.Itoa(class)
+}
+
+// Print writes the prediction on one line: the class and the topK most
+// likely classes for classifiers, every output otherwise.
+func (p Prediction) Print(w io.Writer, topK int) {
+	if p.Probabilities == nil {
+		ids := make([]int, 0, len(p.Outputs))
+		for id := range p.Outputs {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		values := make([]string, len(ids))
+		for i, id := range ids {
+			values[i] = fmt.Sprintf("%d=%.6g", id, p.Outputs[id])
+		}
+		fmt.Fprintf(w, "%s: %s\n", p.Input, strings.Join(values, " "))
+		return
+	}
+
+	top := make([]string, 0, topK)
+	for _, name := range p.ranked[:min(topK, len(p.ranked))] {
+		top = append(top, fmt.Sprintf("%s %.2f%%", name, 100*p.Probabilities[name]))
+	}
+	fmt.Fprintf(w, "%s: %s (top-%d: %s)\n", p.Input, p.Class, len(top), strings.Join(top, ", "))
+}
+
+// readPredictionInputs reads the inputs in a PNG or JPEG image, a CSV or TSV
+// file, a JSON file, or every such file in a directory, for the given model.
+func readPredictionInputs(path string, model *Model) ([]PredictionInput, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readPredictionFile(path, model)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []PredictionInput
+	for _, entry := range entries {
+		if entry.IsDir() || !isPredictionFile(entry.Name()) {
+			continue
+		}
+		file, err := readPredictionFile(filepath.Join(path, entry.Name()), model)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, file...)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no image, CSV, TSV or JSON files in %s", path)
+	}
+	return inputs, nil
+}
+
+// isPredictionFile reports whether readPredictionFile understands the file.
+func isPredictionFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".csv", ".tsv", ".json":
+		return true
+	}
+	return false
+}
+
+// readPredictionFile reads the inputs in one file, chosen by its extension.
+func readPredictionFile(path string, model *Model) ([]PredictionInput, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg":
+		in, err := readImageInput(path, model.InputIDs())
+		if err != nil {
+			return nil, err
+		}
+		return []PredictionInput{in}, nil
+	case ".csv", ".tsv":
+		return readTableInputs(path, model)
+	case ".json":
+		return readJSONInputs(path)
+	}
+	return nil, fmt.Errorf("%s: unsupported input type, use an image, CSV, TSV or JSON file", path)
+}
+
+// readImageInput reads an image file with imageInput.
+func readImageInput(path string, inputIDs []int) (PredictionInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PredictionInput{}, err
+	}
+	defer f.Close()
+	return imageInput(f, path, inputIDs)
+}
+
+// imageInput decodes a PNG or JPEG image into inputs scaled to [0, 1] the
+// way the image datasets are loaded: one luminance input per pixel row by
+// row if the model has width*height inputs, or red, green and blue planes if
+// it has three times as many.
+func imageInput(r io.Reader, name string, inputIDs []int) (PredictionInput, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return PredictionInput{}, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	plane := w * h
+	if len(inputIDs) != plane && len(inputIDs) != 3*plane {
+		return PredictionInput{}, fmt.Errorf("%s is %dx%d, but the model has %d inputs", name, w, h, len(inputIDs))
+	}
+
+	in := PredictionInput{Name: name, Inputs: make(map[int]float64, len(inputIDs))}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			p := y*w + x
+			if len(inputIDs) == plane {
+				in.Inputs[inputIDs[p]] = float64(color.GrayModel.Convert(c).(color.Gray).Y) / 255.0
+				continue
+			}
+			r, g, b, _ := c.RGBA()
+			in.Inputs[inputIDs[p]] = float64(r>>8) / 255.0
+			in.Inputs[inputIDs[plane+p]] = float64(g>>8) / 255.0
+			in.Inputs[inputIDs[2*plane+p]] = float64(b>>8) / 255.0
+		}
+	}
+	return in, nil
+}
+
+// readTableInputs reads one input per row of a CSV or TSV file, or for a
+// sequence model a single window whose steps are the rows. A header of
+// neuron IDs names the input each column feeds. Models trained on a tabular
+// dataset read files with the columns of their training file and encode
+// them like it. Otherwise the header is skipped and the columns feed the
+// model's inputs in order.
+func readTableInputs(path string, model *Model) ([]PredictionInput, error) {
+	inputs, err := readTableRows(path, model)
+	if err != nil || model.Metadata.SequenceWindow == 0 {
+		return inputs, err
+	}
+	window := PredictionInput{Name: path, Steps: make([]map[int]float64, len(inputs))}
+	for t, in := range inputs {
+		window.Steps[t] = in.Inputs
+	}
+	return []PredictionInput{window}, nil
+}
+
+// readTableRows reads one input per row of a CSV or TSV file.
+func readTableRows(path string, model *Model) ([]PredictionInput, error) {
+	header, rows, err := readTable(TabularConfig{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	inputIDs := model.InputIDs()
+
+	ids := make([]int, len(header))
+	for i, name := range header {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			ids = nil
+			break
+		}
+		ids[i] = id
+	}
+	if ids == nil && model.Metadata.Tabular != nil {
+		return encodeTableRows(path, header, rows, model)
+	}
+	if ids == nil {
+		if len(header) != len(inputIDs) {
+			return nil, fmt.Errorf("%s has %d columns, but the model has %d inputs (name columns by neuron ID to feed only some)", path, len(header), len(inputIDs))
+		}
+		ids = inputIDs
+	}
+
+	inputs := make([]PredictionInput, len(rows))
+	for r, row := range rows {
+		in := PredictionInput{Name: fmt.Sprintf("%s:%d", path, r+2), Inputs: make(map[int]float64, len(ids))}
+		for i, id := range ids {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s, row %d: %q is not a number", path, r+2, row[i])
+			}
+			in.Inputs[id] = v
+		}
+		inputs[r] = in
+	}
+	return inputs, nil
+}
+
+// encodeTableRows encodes the rows of a file with the columns of a tabular
+// model's training file.
+func encodeTableRows(path string, header []string, rows [][]string, model *Model) ([]PredictionInput, error) {
+	inputs := make([]PredictionInput, len(rows))
+	for r, row := range rows {
+		cells := make(map[string]string, len(header))
+		for i, name := range header {
+			cells[name] = row[i]
+		}
+		encoded, err := model.EncodeRow(cells)
+		if err != nil {
+			return nil, fmt.Errorf("%s, row %d: %w", path, r+2, err)
+		}
+		inputs[r] = PredictionInput{Name: fmt.Sprintf("%s:%d", path, r+2), Inputs: encoded}
+	}
+	return inputs, nil
+}
+
+// readJSONInputs reads the inputs in a JSON file with decodeJSONInputs.
+func readJSONInputs(path string) ([]PredictionInput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONInputs(data, path)
+}
+
+// decodeJSONInputs decodes one input or a list of inputs. An input is an
+// object with an "inputs" map or a "steps" list of them and optional "name"
+// and "timesteps", or a bare object mapping neuron IDs to values. Unnamed
+// inputs are named after source.
+func decodeJSONInputs(data []byte, source string) ([]PredictionInput, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+
+	inputs := make([]PredictionInput, len(items))
+	for i, item := range items {
+		in := &inputs[i]
+		if err := json.Unmarshal(item, in); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", source, err)
+		}
+		if in.Inputs == nil && in.Steps == nil {
+			if err := json.Unmarshal(item, &in.Inputs); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", source, err)
+			}
+		}
+		if in.Name == "" {
+			in.Name = source
+			if len(items) > 1 {
+				in.Name = fmt.Sprintf("%s[%d]", source, i)
+			}
+		}
+	}
+	return inputs, nil
+}
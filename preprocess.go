@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Preprocessing steps.
+const (
+	preprocessMinMax    = "minmax"     // scale every input to [0, 1] using its training range
+	preprocessZScore    = "zscore"     // subtract every input's mean and divide by its standard deviation
+	preprocessMean      = "mean"       // subtract every input's mean, e.g. the mean image per pixel
+	preprocessPCAWhiten = "pca-whiten" // project onto the top principal components, scaled to unit variance
+)
+
+// Defaults of PCA whitening.
+const (
+	defaultPCAComponents = 50
+	defaultPCAEpsilon    = 1e-5
+	// pcaFitSamples bounds the rows the covariance matrix is estimated from.
+	pcaFitSamples = 10000
+	// pcaIterations bounds the power iterations per component.
+	pcaIterations = 200
+)
+
+// PreprocessStep is one stage of an input preprocessing pipeline. An
+// experiment only sets Kind and, for PCA whitening, Components and Epsilon;
+// the remaining fields are fitted on the training data.
+type PreprocessStep struct {
+	Kind       string  `json:"kind"`
+	Components int     `json:"components,omitempty"` // pca-whiten: number of components kept (default 50)
+	Epsilon    float64 `json:"epsilon,omitempty"`    // pca-whiten: added to each variance before scaling (default 1e-5)
+
+	// InputIDs are the inputs the step reads, in the order of its statistics.
+	InputIDs []int     `json:"input_ids,omitempty"`
+	Min      []float64 `json:"min,omitempty"`
+	Max      []float64 `json:"max,omitempty"`
+	Mean     []float64 `json:"mean,omitempty"`
+	Std      []float64 `json:"std,omitempty"`
+	// Whitening holds one row per component: the component divided by the
+	// square root of its variance. Component i feeds input neuron i+1.
+	Whitening [][]float64 `json:"whitening,omitempty"`
+}
+
+// Validate checks the settings of the step.
+func (s PreprocessStep) Validate() error {
+	switch s.Kind {
+	case preprocessMinMax, preprocessZScore, preprocessMean, preprocessPCAWhiten:
+	default:
+		return fmt.Errorf("unknown preprocessing step %q", s.Kind)
+	}
+	if s.Components < 0 || s.Epsilon < 0 {
+		return fmt.Errorf("%s: components and epsilon must not be negative", s.Kind)
+	}
+	return nil
+}
+
+// outputIDs returns the inputs the step writes.
+func (s *PreprocessStep) outputIDs() []int {
+	if s.Kind != preprocessPCAWhiten {
+		return s.InputIDs
+	}
+	ids := make([]int, len(s.Whitening))
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	return ids
+}
+
+// apply transforms one row of values ordered like InputIDs.
+func (s *PreprocessStep) apply(row []float64) []float64 {
+	out := make([]float64, len(row))
+	switch s.Kind {
+	case preprocessMinMax:
+		for i, v := range row {
+			if span := s.Max[i] - s.Min[i]; span > 0 {
+				out[i] = (v - s.Min[i]) / span
+			}
+		}
+	case preprocessZScore:
+		for i, v := range row {
+			if s.Std[i] > 0 {
+				out[i] = (v - s.Mean[i]) / s.Std[i]
+			}
+		}
+	case preprocessMean:
+		for i, v := range row {
+			out[i] = v - s.Mean[i]
+		}
+	case preprocessPCAWhiten:
+		out = make([]float64, len(s.Whitening))
+		for c, w := range s.Whitening {
+			var sum float64
+			for i, v := range row {
+				sum += w[i] * (v - s.Mean[i])
+			}
+			out[c] = sum
+		}
+	}
+	return out
+}
+
+// fit estimates the statistics of the step from the rows of data.
+func (s *PreprocessStep) fit(data *DenseDataset) {
+	d := len(data.InputIDs)
+	s.InputIDs = append([]int(nil), data.InputIDs...)
+	s.Min = make([]float64, d)
+	s.Max = make([]float64, d)
+	s.Mean = make([]float64, d)
+	s.Std = make([]float64, d)
+
+	n := data.Len()
+	for i := 0; i < n; i++ {
+		for j, v := range data.InputRow(i) {
+			f := float64(v)
+			if i == 0 || f < s.Min[j] {
+				s.Min[j] = f
+			}
+			if i == 0 || f > s.Max[j] {
+				s.Max[j] = f
+			}
+			s.Mean[j] += f / float64(n)
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j, v := range data.InputRow(i) {
+			diff := float64(v) - s.Mean[j]
+			s.Std[j] += diff * diff / float64(n)
+		}
+	}
+	for j := range s.Std {
+		s.Std[j] = math.Sqrt(s.Std[j])
+	}
+
+	// Keep only the statistics the step uses
+	switch s.Kind {
+	case preprocessMinMax:
+		s.Mean, s.Std = nil, nil
+	case preprocessZScore:
+		s.Min, s.Max = nil, nil
+	case preprocessMean:
+		s.Min, s.Max, s.Std = nil, nil, nil
+	case preprocessPCAWhiten:
+		s.Min, s.Max, s.Std = nil, nil, nil
+		s.fitWhitening(data)
+	}
+}
+
+// fitWhitening finds the top principal components of the rows by power
+// iteration with deflation on their covariance matrix.
+func (s *PreprocessStep) fitWhitening(data *DenseDataset) {
+	d := len(s.InputIDs)
+	components := s.Components
+	if components == 0 {
+		components = defaultPCAComponents
+	}
+	components = min(components, d)
+	epsilon := s.Epsilon
+	if epsilon == 0 {
+		epsilon = defaultPCAEpsilon
+	}
+
+	// Covariance of evenly spaced rows
+	rows := min(data.Len(), pcaFitSamples)
+	cov := make([][]float64, d)
+	for i := range cov {
+		cov[i] = make([]float64, d)
+	}
+	centred := make([]float64, d)
+	for r := 0; r < rows; r++ {
+		for j, v := range data.InputRow(r * data.Len() / rows) {
+			centred[j] = float64(v) - s.Mean[j]
+		}
+		for i := 0; i < d; i++ {
+			for j := i; j < d; j++ {
+				cov[i][j] += centred[i] * centred[j]
+			}
+		}
+	}
+	for i := 0; i < d; i++ {
+		for j := i; j < d; j++ {
+			cov[i][j] /= float64(rows)
+			cov[j][i] = cov[i][j]
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	s.Whitening = nil
+	for c := 0; c < components; c++ {
+		vector := make([]float64, d)
+		for i := range vector {
+			vector[i] = rng.NormFloat64()
+		}
+		normalize(vector)
+
+		var variance float64
+		next := make([]float64, d)
+		for it := 0; it < pcaIterations; it++ {
+			for i, row := range cov {
+				var sum float64
+				for j, v := range row {
+					sum += v * vector[j]
+				}
+				next[i] = sum
+			}
+			variance = normalize(next)
+			converged := true
+			for i := range vector {
+				if math.Abs(next[i]-vector[i]) > 1e-9 {
+					converged = false
+				}
+				vector[i] = next[i]
+			}
+			if converged {
+				break
+			}
+		}
+
+		// Remove the component from the covariance before finding the next
+		for i := range cov {
+			for j := range cov[i] {
+				cov[i][j] -= variance * vector[i] * vector[j]
+			}
+		}
+
+		scale := 1 / math.Sqrt(math.Max(variance, 0)+epsilon)
+		row := make([]float64, d)
+		for i, v := range vector {
+			row[i] = v * scale
+		}
+		s.Whitening = append(s.Whitening, row)
+	}
+}
+
+// normalize scales v to unit length in place and returns its former length.
+func normalize(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	length := math.Sqrt(sum)
+	if length > 0 {
+		for i := range v {
+			v[i] /= length
+		}
+	}
+	return length
+}
+
+// Pipeline is a fitted sequence of preprocessing steps. It is saved with the
+// model under the "hammer" key so inputs can be prepared the same way when
+// the model is loaded for inference.
+type Pipeline struct {
+	Steps []PreprocessStep `json:"steps"`
+}
+
+// FitPipeline fits the steps one after another on the training data, each on
+// the output of the previous one, and returns the pipeline and the
+// transformed data.
+func FitPipeline(steps []PreprocessStep, data *DenseDataset) (*Pipeline, *DenseDataset) {
+	p := &Pipeline{}
+	for _, step := range steps {
+		step.fit(data)
+		p.Steps = append(p.Steps, step)
+		data = transformDense(&p.Steps[len(p.Steps)-1], data)
+	}
+	log.Printf("Fitted preprocessing %s on %d sessions: %d inputs become %d.",
+		p, data.Len(), len(p.Steps[0].InputIDs), len(data.InputIDs))
+	return p, data
+}
+
+// String lists the steps, e.g. "mean, pca-whiten".
+func (p *Pipeline) String() string {
+	kinds := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		kinds[i] = step.Kind
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// InputIDs returns the raw inputs the pipeline reads.
+func (p *Pipeline) InputIDs() []int {
+	if len(p.Steps) == 0 {
+		return nil
+	}
+	return p.Steps[0].InputIDs
+}
+
+// Transform prepares a dataset laid out like the one the pipeline was fitted on.
+func (p *Pipeline) Transform(data *DenseDataset) (*DenseDataset, error) {
+	want := p.InputIDs()
+	if len(want) != len(data.InputIDs) {
+		return nil, fmt.Errorf("preprocessing expects %d inputs, dataset has %d", len(want), len(data.InputIDs))
+	}
+	for i, id := range want {
+		if data.InputIDs[i] != id {
+			return nil, fmt.Errorf("preprocessing expects input %d in column %d, dataset has %d", id, i, data.InputIDs[i])
+		}
+	}
+	for i := range p.Steps {
+		data = transformDense(&p.Steps[i], data)
+	}
+	return data, nil
+}
+
+// TransformInputs prepares the inputs of one session; inputs the pipeline
+// reads but which are missing count as 0.
+func (p *Pipeline) TransformInputs(inputs map[int]float64) map[int]float64 {
+	ids := p.InputIDs()
+	row := make([]float64, len(ids))
+	for i, id := range ids {
+		row[i] = inputs[id]
+	}
+	for i := range p.Steps {
+		row = p.Steps[i].apply(row)
+		ids = p.Steps[i].outputIDs()
+	}
+
+	out := make(map[int]float64, len(ids))
+	for i, id := range ids {
+		out[id] = row[i]
+	}
+	return out
+}
+
+// transformDense applies one fitted step to every row of data.
+func transformDense(step *PreprocessStep, data *DenseDataset) *DenseDataset {
+	out := NewDenseDataset(step.outputIDs(), data.OutputIDs, data.Len())
+	out.Timesteps = data.Timesteps
+	if step.Kind != preprocessPCAWhiten {
+		out.Image = data.Image
+	}
+
+	row := make([]float64, len(data.InputIDs))
+	converted := make([]float32, len(out.InputIDs))
+	for i := 0; i < data.Len(); i++ {
+		for j, v := range data.InputRow(i) {
+			row[j] = float64(v)
+		}
+		for j, v := range step.apply(row) {
+			converted[j] = float32(v)
+		}
+		out.Append(converted, data.OutputRow(i))
+	}
+	return out
+}
+
+// preprocessTraining applies the experiment's preprocessing to the training
+// data. A new run fits the pipeline and records it in the run's metadata; a
+// resumed run reuses the recorded one.
+func preprocessTraining(run *TrainingRun, data *DenseDataset) (*DenseDataset, error) {
+	steps := run.Experiment.Dataset.Preprocess
+	if len(steps) == 0 {
+		return data, nil
+	}
+	if run.Metadata.Preprocessing == nil {
+		p, transformed := FitPipeline(steps, data)
+		run.Metadata.Preprocessing = p
+		return transformed, nil
+	}
+	return run.Metadata.Preprocessing.Transform(data)
+}
+
+// preprocessHeldOut applies the run's fitted preprocessing, if any, to
+// held-out data.
+func preprocessHeldOut(run *TrainingRun, data *DenseDataset) (*DenseDataset, error) {
+	if run.Metadata.Preprocessing == nil {
+		return data, nil
+	}
+	return run.Metadata.Preprocessing.Transform(data)
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"blueprint"
+)
+
+// replBuiltins are the REPL's own commands; every other command is a
+// Blueprint method.
+var replBuiltins = map[string]string{
+	"help":    "help [method]           list the commands, or describe one method",
+	"methods": "methods                 list the Blueprint methods and their parameters",
+	"neurons": "neurons                 list the neurons by ID with their type and activation",
+	"neuron":  "neuron <id>             show one neuron as JSON",
+	"load":    "load <model.json>       replace the network with a saved model",
+	"reset":   "reset                   start over with an empty network",
+	"exit":    "exit                    leave the REPL (also Ctrl-D)",
+	"quit":    "quit                    same as exit",
+}
+
+// errReplExit ends the REPL loop.
+var errReplExit = errors.New("exit")
+
+// Repl calls Blueprint methods typed at a prompt. Arguments are parsed by
+// the parameter types GetBlueprintMethods reports and the calls are made
+// with callMethod, like the JSON-RPC server does.
+type Repl struct {
+	bp      *blueprint.Blueprint
+	methods map[string]blueprint.MethodInfo
+	names   []string // builtins and methods, sorted, for completion
+	out     io.Writer
+}
+
+// NewRepl creates a REPL on bp.
+func NewRepl(bp *blueprint.Blueprint, out io.Writer) (*Repl, error) {
+	infos, err := bp.GetBlueprintMethods()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve blueprint methods: %w", err)
+	}
+	r := &Repl{bp: bp, methods: make(map[string]blueprint.MethodInfo, len(infos)), out: out}
+	for _, info := range infos {
+		r.methods[info.MethodName] = info
+		r.names = append(r.names, info.MethodName)
+	}
+	for name := range replBuiltins {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+	return r, nil
+}
+
+// Run reads commands from in until it ends or exit is typed. A terminal gets
+// a line editor with history and tab completion; anything else, such as a
+// piped script, is read line by line without prompts.
+func (r *Repl) Run(in *os.File) error {
+	if editor, err := newLineEditor(in, r.out, r.complete); err == nil {
+		defer editor.Close()
+		// Signals are caught for the whole session, so the terminal
+		// settings are restored however it ends
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		fmt.Fprintln(r.out, `Type "help" for commands, Tab to complete.`)
+		for {
+			line, sig, err := readLineOrSignal(editor, "hammer> ", signals)
+			if sig != nil {
+				fmt.Fprintf(r.out, "\nReceived %v, leaving the REPL.\n", sig)
+				return nil
+			}
+			if err == io.EOF {
+				fmt.Fprintln(r.out)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := r.execInterruptible(editor, line, signals); err == errReplExit {
+				return nil
+			} else if err != nil {
+				fmt.Fprintf(r.out, "error: %v\n", err)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 1<<20), maxRequestBytes)
+	for n := 1; scanner.Scan(); n++ {
+		if err := r.Exec(scanner.Text()); err == errReplExit {
+			return nil
+		} else if err != nil {
+			// A failing script line is reported but does not stop the script
+			fmt.Fprintf(r.out, "line %d: %v\n", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// readLineOrSignal reads a line with the editor until one of signals
+// arrives. The read is left pending after a signal, which ends the REPL.
+func readLineOrSignal(editor *lineEditor, prompt string, signals <-chan os.Signal) (string, os.Signal, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	lines := make(chan result, 1)
+	go func() {
+		line, err := editor.ReadLine(prompt)
+		lines <- result{line, err}
+	}()
+	select {
+	case res := <-lines:
+		return res.line, nil, res.err
+	case sig := <-signals:
+		return "", sig, nil
+	}
+}
+
+// execInterruptible runs Exec with Ctrl-C turned back into a signal, so a
+// long call can be abandoned; SIGTERM abandons it and leaves the REPL. The
+// call cannot be stopped and keeps running on its network in the
+// background, so the REPL goes on with an empty one.
+func (r *Repl) execInterruptible(editor *lineEditor, line string, signals <-chan os.Signal) error {
+	if err := editor.allowSignals(true); err != nil {
+		return err
+	}
+	defer editor.allowSignals(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan os.Signal, 1)
+	go func() {
+		select {
+		case sig := <-signals:
+			received <- sig
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The call runs on a copy so an abandoned one cannot change r
+	call := *r
+	err := runCancellableErr(ctx, func() error { return call.Exec(line) })
+	if errors.Is(err, errInterrupted) {
+		r.bp = blueprint.NewBlueprint()
+		if sig := <-received; sig == syscall.SIGTERM {
+			fmt.Fprintf(r.out, "Received %v, abandoning the call and leaving the REPL.\n", sig)
+			return errReplExit
+		}
+		return fmt.Errorf("call abandoned; continuing with an empty network (use load to restore a saved model)")
+	}
+	r.bp = call.bp
+	return err
+}
+
+// Exec runs one command line. Lines starting with # are comments. A panic in
+// a command is returned as an error.
+func (r *Repl) Exec(line string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	words, err := splitReplLine(line)
+	if err != nil {
+		return err
+	}
+	name, args := words[0], words[1:]
+
+	switch name {
+	case "exit", "quit":
+		return errReplExit
+	case "help":
+		return r.help(args)
+	case "methods":
+		for _, name := range r.names {
+			if info, ok := r.methods[name]; ok {
+				fmt.Fprintf(r.out, "  %s%s\n", name, describeParams(info))
+			}
+		}
+		return nil
+	case "neurons":
+		r.listNeurons()
+		return nil
+	case "neuron":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", replBuiltins["neuron"])
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("neuron ID must be an integer, got %q", args[0])
+		}
+		neuron, ok := r.bp.Neurons[id]
+		if !ok {
+			return fmt.Errorf("no neuron %d", id)
+		}
+		return r.print(neuron)
+	case "load":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", replBuiltins["load"])
+		}
+		model, err := LoadModelJSON(args[0])
+		if err != nil {
+			return err
+		}
+		r.bp = model.Blueprint
+		fmt.Fprintf(r.out, "Loaded %d neurons from %s.\n", len(r.bp.Neurons), args[0])
+		return nil
+	case "reset":
+		r.bp = blueprint.NewBlueprint()
+		return nil
+	}
+
+	info, ok := r.methods[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q (type help)", name)
+	}
+	if len(args) != len(info.Parameters) {
+		return fmt.Errorf("%s takes %d arguments, got %d: %s", name, len(info.Parameters), len(args), describeParams(info))
+	}
+	raw := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		if raw[i], err = replArg(arg, info.Parameters[i].Type); err != nil {
+			return fmt.Errorf("%s: parameter %s: %w", name, info.Parameters[i].Name, err)
+		}
+	}
+	list, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	result, err := callMethod(r.bp, info, list)
+	if err != nil {
+		return err
+	}
+	return r.print(result)
+}
+
+// help lists the commands, or describes the methods named in args.
+func (r *Repl) help(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(r.out, "Commands:")
+		for _, name := range r.names {
+			if usage := replBuiltins[name]; usage != "" {
+				fmt.Fprintf(r.out, "  %s\n", usage)
+			}
+		}
+		fmt.Fprintln(r.out, "  <Method> <args...>      call a Blueprint method (see methods)")
+		fmt.Fprintln(r.out, "\nArguments are JSON, or shorthand by parameter type: 1,2,3 for a slice,")
+		fmt.Fprintln(r.out, "1=0.5,2=0.25 for a map and bare words for strings. @file.json reads an")
+		fmt.Fprintln(r.out, "argument from a file, e.g. training sessions.")
+		return nil
+	}
+	for _, name := range args {
+		info, ok := r.methods[name]
+		if !ok {
+			if usage, builtin := replBuiltins[name]; builtin {
+				fmt.Fprintf(r.out, "  %s\n", usage)
+				continue
+			}
+			return fmt.Errorf("unknown method %q", name)
+		}
+		fmt.Fprintf(r.out, "  %s%s\n", name, describeParams(info))
+	}
+	return nil
+}
+
+// listNeurons prints one line per neuron in ID order, marking the inputs and
+// outputs.
+func (r *Repl) listNeurons() {
+	ids := make([]int, 0, len(r.bp.Neurons))
+	for id := range r.bp.Neurons {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	role := make(map[int]string)
+	for _, id := range r.bp.InputNodes {
+		role[id] = " (input)"
+	}
+	for _, id := range r.bp.OutputNodes {
+		role[id] = " (output)"
+	}
+	for _, id := range ids {
+		n := r.bp.Neurons[id]
+		fmt.Fprintf(r.out, "  %4d %-10s %-10s bias=%.4g connections=%d%s\n",
+			id, n.Type, n.Activation, n.Bias, len(n.Connections), role[id])
+	}
+	fmt.Fprintf(r.out, "%d neurons\n", len(ids))
+}
+
+// print writes a method result: strings as they are, nothing for a method
+// without results and anything else as indented JSON.
+func (r *Repl) print(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		fmt.Fprintln(r.out, v)
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the result: %w", err)
+	}
+	fmt.Fprintln(r.out, string(data))
+	return nil
+}
+
+// splitReplLine splits a command line at spaces outside quotes, brackets and
+// braces, so JSON arguments may contain spaces.
+func splitReplLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	depth := 0
+	inString, escaped := false, false
+	for _, c := range line {
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		word.WriteRune(c)
+	}
+	if inString || depth != 0 {
+		return nil, fmt.Errorf("unterminated string, list or object")
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// replArg turns one typed argument into JSON for a parameter of Go type typ.
+// @path reads the argument from a file and JSON is passed through, except
+// that a string parameter takes an unquoted argument as its text. Otherwise
+// slices take comma-separated elements and maps comma-separated key=value
+// pairs; numbers and booleans are already JSON.
+func replArg(arg, typ string) (json.RawMessage, error) {
+	if strings.HasPrefix(arg, "@") {
+		data, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if strings.HasPrefix(arg, `"`) {
+		return json.RawMessage(arg), nil
+	}
+	// A string parameter takes anything else as its text, so JSON documents
+	// such as a neuron configuration can be typed unquoted
+	if typ == "string" {
+		return json.Marshal(arg)
+	}
+	if strings.HasPrefix(arg, "[") || strings.HasPrefix(arg, "{") {
+		return json.RawMessage(arg), nil
+	}
+
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		elems := make([]json.RawMessage, 0)
+		for _, elem := range strings.Split(arg, ",") {
+			raw, err := replArg(strings.TrimSpace(elem), typ[2:])
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, raw)
+		}
+		return json.Marshal(elems)
+	case strings.HasPrefix(typ, "map["):
+		valueType := typ[mapKeyEnd(typ)+1:]
+		entries := make(map[string]json.RawMessage)
+		for _, pair := range strings.Split(arg, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value pairs, got %q", pair)
+			}
+			raw, err := replArg(strings.TrimSpace(value), valueType)
+			if err != nil {
+				return nil, err
+			}
+			entries[strings.TrimSpace(key)] = raw
+		}
+		return json.Marshal(entries)
+	}
+	if !json.Valid([]byte(arg)) {
+		return nil, fmt.Errorf("%q is not a valid %s", arg, typ)
+	}
+	return json.RawMessage(arg), nil
+}
+
+// mapKeyEnd returns the index of the bracket closing the key type of a map
+// type such as "map[int][]float64".
+func mapKeyEnd(typ string) int {
+	depth := 0
+	for i, c := range typ {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(typ) - 1
+}
+
+// complete returns the completions of the last word of line and, once a
+// method name is complete, a hint naming the parameter being typed.
+func (r *Repl) complete(line string) (candidates []string, hint string) {
+	words, err := splitReplLine(line)
+	if err != nil {
+		return nil, ""
+	}
+	current := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	var pool []string
+	switch {
+	case len(words) == 0:
+		pool = r.names
+	case words[0] == "help" && len(words) == 1:
+		pool = r.names
+	case words[0] == "neuron" && len(words) == 1:
+		for id := range r.bp.Neurons {
+			pool = append(pool, strconv.Itoa(id))
+		}
+		sort.Strings(pool)
+	default:
+		info, ok := r.methods[words[0]]
+		if !ok {
+			return nil, ""
+		}
+		arg := len(words) - 1
+		if arg >= len(info.Parameters) {
+			return nil, info.MethodName + describeParams(info) + ": no more arguments"
+		}
+		p := info.Parameters[arg]
+		return nil, fmt.Sprintf("%s%s: argument %d is %s %s", info.MethodName, describeParams(info), arg+1, p.Name, p.Type)
+	}
+
+	for _, name := range pool {
+		if strings.HasPrefix(name, current) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates, ""
+}
+
+// lineEditor reads lines from a terminal with Tab completion and Up/Down
+// history. The terminal is switched out of canonical mode with stty, so it
+// is only available where stty is.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	tty      *os.File
+	saved    string // stty settings to restore
+	complete func(line string) ([]string, string)
+	history  []string
+}
+
+// newLineEditor prepares tty for reading key by key. It fails if tty is not
+// a terminal.
+func newLineEditor(tty *os.File, out io.Writer, complete func(string) ([]string, string)) (*lineEditor, error) {
+	info, err := tty.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("not a terminal")
+	}
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		return nil, err
+	}
+	// Keep output processing so "\n" still returns the carriage; Ctrl-C
+	// arrives as a key instead of a signal
+	if _, err := stty(tty, "-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return nil, err
+	}
+	return &lineEditor{in: bufio.NewReader(tty), out: out, tty: tty, saved: strings.TrimSpace(saved), complete: complete}, nil
+}
+
+// allowSignals switches whether Ctrl-C sends SIGINT instead of arriving as
+// a key.
+func (e *lineEditor) allowSignals(on bool) error {
+	if on {
+		_, err := stty(e.tty, "isig")
+		return err
+	}
+	_, err := stty(e.tty, "-isig")
+	return err
+}
+
+// stty runs stty on the terminal.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to configure the terminal: %w", err)
+	}
+	return string(out), nil
+}
+
+// Close restores the terminal settings.
+func (e *lineEditor) Close() error {
+	_, err := stty(e.tty, e.saved)
+	return err
+}
+
+// ReadLine reads one line. Ctrl-C discards the line, Ctrl-D on an empty line
+// returns io.EOF.
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	var line []rune
+	pos := len(e.history) // position in history; len means the new line
+	redraw := func() { fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(line)) }
+	redraw()
+
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Fprintln(e.out)
+			text := string(line)
+			if strings.TrimSpace(text) != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != text) {
+				e.history = append(e.history, text)
+			}
+			return text, nil
+		case 3: // Ctrl-C
+			fmt.Fprintln(e.out, "^C")
+			line = line[:0]
+			redraw()
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+		case 127, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+		case '\t':
+			line = e.completeLine(line)
+			redraw()
+		case 0x1b: // escape sequence; only the Up and Down arrows are handled
+			if next, _, _ := e.in.ReadRune(); next != '[' {
+				continue
+			}
+			key, _, _ := e.in.ReadRune()
+			switch {
+			case key == 'A' && pos > 0:
+				pos--
+			case key == 'B' && pos < len(e.history):
+				pos++
+			default:
+				continue
+			}
+			line = line[:0]
+			if pos < len(e.history) {
+				line = append(line, []rune(e.history[pos])...)
+			}
+			redraw()
+		default:
+			if c >= ' ' {
+				line = append(line, c)
+				fmt.Fprint(e.out, string(c))
+			}
+		}
+	}
+}
+
+// completeLine extends the last word of line to the longest prefix its
+// candidates share, listing the candidates or the parameter hint when that
+// does not finish the word.
+func (e *lineEditor) completeLine(line []rune) []rune {
+	text := string(line)
+	candidates, hint := e.complete(text)
+	if hint != "" {
+		fmt.Fprintf(e.out, "\n%s\n", hint)
+		return line
+	}
+	if len(candidates) == 0 {
+		return line
+	}
+
+	start := strings.LastIndexAny(text, " \t") + 1
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		prefix += " "
+	} else if len(prefix) == len(text)-start {
+		fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+	}
+	return []rune(text[:start] + prefix)
+}
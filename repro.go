@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxReproDiffLines caps how many differing lines checkReproducibility prints.
+const maxReproDiffLines = 20
+
+// checkReproducibility runs a scenario twice with the same seed and fails if
+// the two resulting networks serialise differently.
+func checkReproducibility(ctx context.Context, name string, seed int64, run func(rc *RunContext) error) error {
+	var outputs [2]string
+	for i := range outputs {
+		fmt.Printf("---%s (reproducibility run %d/2)---\n", name, i+1)
+		rc, err := runScenario(ctx, name, seed, run)
+		if err != nil {
+			return err
+		}
+		if rc.Blueprint == nil {
+			return fmt.Errorf("scenario %s does not produce a blueprint to compare", name)
+		}
+
+		outputs[i], err = rc.Blueprint.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
+		}
+	}
+
+	if outputs[0] == outputs[1] {
+		fmt.Printf("Scenario %s is reproducible with seed %d.\n", name, seed)
+		return nil
+	}
+
+	fmt.Printf("Scenario %s produced different networks with seed %d:\n", name, seed)
+	printLineDiff(outputs[0], outputs[1])
+	return fmt.Errorf("scenario %s is not reproducible", name)
+}
+
+// printLineDiff prints the lines that differ between two texts, position by position.
+func printLineDiff(a, b string) {
+	linesA := strings.Split(a, "\n")
+	linesB := strings.Split(b, "\n")
+
+	n := len(linesA)
+	if len(linesB) > n {
+		n = len(linesB)
+	}
+
+	shown := 0
+	for i := 0; i < n; i++ {
+		var lineA, lineB string
+		if i < len(linesA) {
+			lineA = linesA[i]
+		}
+		if i < len(linesB) {
+			lineB = linesB[i]
+		}
+		if lineA == lineB {
+			continue
+		}
+
+		if shown == maxReproDiffLines {
+			fmt.Println("...")
+			return
+		}
+		fmt.Printf("line %d:\n  - %s\n  + %s\n", i+1, lineA, lineB)
+		shown++
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"blueprint"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcCallFailed     = -32000 // the Blueprint method returned an error
+	rpcUnknownSession = -32001
+)
+
+// rpcTokenEnv sets the token of "hammer rpc" when no --token flag is given.
+const rpcTokenEnv = "HAMMER_RPC_TOKEN"
+
+// Built-in RPC methods; every other method name is a Blueprint method.
+const (
+	rpcCreateSession = "session.create"
+	rpcCloseSession  = "session.close"
+	rpcListMethods   = "methods.list"
+)
+
+// rpcRequest is a JSON-RPC 2.0 request. A request without an ID is a
+// notification and gets no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error member of a response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// rpcErrorf formats an rpcError.
+func rpcErrorf(code int, format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// callParams are the params of a Blueprint method call. Args is either a
+// list in parameter order or an object keyed by parameter name.
+type callParams struct {
+	Session string          `json:"session"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// rpcSession is a Blueprint owned by one client. Calls on a session are
+// serialised because a Blueprint is not safe for concurrent use.
+type rpcSession struct {
+	mu       sync.Mutex
+	bp       *blueprint.Blueprint
+	lastUsed time.Time
+}
+
+// RPCServer dispatches JSON-RPC calls to the methods of session-scoped
+// Blueprints. Calls are checked against the method metadata reported by
+// GetBlueprintMethods before they are made by reflection.
+//
+// Blueprint methods read and write files, so every request must carry the
+// server's token as a bearer token. Requests must also be JSON and may not
+// come from a web page of another origin, which keeps browsers from
+// reaching the server on a visitor's behalf.
+type RPCServer struct {
+	methods map[string]blueprint.MethodInfo
+	token   string
+
+	mu       sync.Mutex
+	sessions map[string]*rpcSession
+}
+
+// NewRPCServer reads the Blueprint method metadata. Clients authenticate
+// with token.
+func NewRPCServer(token string) (*RPCServer, error) {
+	infos, err := blueprint.NewBlueprint().GetBlueprintMethods()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve blueprint methods: %w", err)
+	}
+	s := &RPCServer{
+		methods:  make(map[string]blueprint.MethodInfo, len(infos)),
+		token:    token,
+		sessions: make(map[string]*rpcSession),
+	}
+	for _, info := range infos {
+		s.methods[info.MethodName] = info
+	}
+	return s, nil
+}
+
+// ServeHTTP answers POST /rpc with single or batched JSON-RPC requests.
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/rpc" {
+		writeError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if status, msg := s.checkRequest(r); status != http.StatusOK {
+		writeError(w, status, msg)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	// A batch is answered with the list of its non-notification responses
+	trimmed := strings.TrimSpace(string(body))
+	if strings.HasPrefix(trimmed, "[") {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			writeJSON(w, http.StatusOK, errorResponse(nil, rpcErrorf(rpcInvalidRequest, "invalid batch")))
+			return
+		}
+		var responses []rpcResponse
+		for _, raw := range batch {
+			if resp, ok := s.handle(raw); ok {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	resp, ok := s.handle(body)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// checkRequest checks the token, content type and origin of a request and
+// returns the status to reject it with, or http.StatusOK.
+func (s *RPCServer) checkRequest(r *http.Request) (int, string) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return http.StatusUnauthorized, "missing or wrong bearer token"
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, "use Content-Type: application/json"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return http.StatusForbidden, "cross-origin requests are not allowed"
+		}
+	}
+	return http.StatusOK, ""
+}
+
+// handle runs one request and reports whether it needs a response. JSON
+// that is not a request object, such as a number in a batch, is an invalid
+// request rather than a parse error.
+func (s *RPCServer) handle(raw []byte) (rpcResponse, bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return errorResponse(nil, rpcErrorf(rpcInvalidRequest, "expected a JSON-RPC 2.0 request object: %v", err)), true
+		}
+		return errorResponse(nil, rpcErrorf(rpcParseError, "invalid JSON: %v", err)), true
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, rpcErrorf(rpcInvalidRequest, "expected a JSON-RPC 2.0 request with a method")), true
+	}
+
+	result, err := s.dispatch(req.Method, req.Params)
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = rpcErrorf(rpcInternalError, "%v", err)
+		}
+		return errorResponse(req.ID, rerr), true
+	}
+
+	// Encode here so a result that cannot be encoded becomes an error
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, rpcErrorf(rpcInternalError, "result of %s cannot be encoded: %v", req.Method, err)), true
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: encoded}, true
+}
+
+// errorResponse builds a response carrying err.
+func errorResponse(id json.RawMessage, err *rpcError) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// dispatch runs a built-in method or calls a Blueprint method.
+func (s *RPCServer) dispatch(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case rpcCreateSession:
+		var p struct {
+			Model string `json:"model,omitempty"` // optional model file to start from
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, rpcErrorf(rpcInvalidParams, "%v", err)
+			}
+		}
+		return s.createSession(p.Model)
+
+	case rpcCloseSession:
+		var p callParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, rpcErrorf(rpcInvalidParams, "%v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.sessions[p.Session]; !ok {
+			return nil, rpcErrorf(rpcUnknownSession, "unknown session %q", p.Session)
+		}
+		delete(s.sessions, p.Session)
+		return true, nil
+
+	case rpcListMethods:
+		infos := make([]blueprint.MethodInfo, 0, len(s.methods))
+		for _, info := range s.methods {
+			infos = append(infos, info)
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].MethodName < infos[j].MethodName })
+		return infos, nil
+	}
+
+	info, ok := s.methods[method]
+	if !ok {
+		return nil, rpcErrorf(rpcMethodNotFound, "unknown method %q (see %s)", method, rpcListMethods)
+	}
+	var p callParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, rpcErrorf(rpcInvalidParams, "%v", err)
+	}
+	session, err := s.session(p.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return callMethod(session.bp, info, p.Args)
+}
+
+// createSession starts a session with an empty Blueprint or a saved model.
+func (s *RPCServer) createSession(modelPath string) (interface{}, error) {
+	bp := blueprint.NewBlueprint()
+	if modelPath != "" {
+		model, err := LoadModelJSON(modelPath)
+		if err != nil {
+			return nil, rpcErrorf(rpcCallFailed, "%v", err)
+		}
+		bp = model.Blueprint
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	name := hex.EncodeToString(id[:])
+
+	s.mu.Lock()
+	s.sessions[name] = &rpcSession{bp: bp, lastUsed: time.Now()}
+	s.mu.Unlock()
+	return map[string]string{"session": name}, nil
+}
+
+// newRPCToken returns a random bearer token.
+func newRPCToken() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", fmt.Errorf("failed to generate a token: %w", err)
+	}
+	return hex.EncodeToString(token[:]), nil
+}
+
+// session looks up a session and marks it as used.
+func (s *RPCServer) session(name string) (*rpcSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[name]
+	if !ok {
+		return nil, rpcErrorf(rpcUnknownSession, "unknown session %q (create one with %s)", name, rpcCreateSession)
+	}
+	session.lastUsed = time.Now()
+	return session, nil
+}
+
+// ExpireSessions drops sessions idle for longer than ttl, checking every
+// minute, or twice per ttl if that is shorter, until ctx is done.
+func (s *RPCServer) ExpireSessions(ctx context.Context, ttl time.Duration) {
+	interval := min(ttl/2, time.Minute)
+	if interval <= 0 {
+		interval = ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		for name, session := range s.sessions {
+			if time.Since(session.lastUsed) > ttl {
+				delete(s.sessions, name)
+				log.Printf("Session %s expired.", name)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// errorType is the reflect.Type of the error interface.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callMethod checks args against the method's metadata, decodes each into
+// the parameter's Go type and calls the method. A trailing error result
+// becomes an RPC error; the remaining results are returned as one value, a
+// list, or nil.
+func callMethod(bp *blueprint.Blueprint, info blueprint.MethodInfo, rawArgs json.RawMessage) (interface{}, error) {
+	method := reflect.ValueOf(bp).MethodByName(info.MethodName)
+	if !method.IsValid() {
+		return nil, rpcErrorf(rpcMethodNotFound, "method %q is not callable", info.MethodName)
+	}
+	methodType := method.Type()
+	if methodType.NumIn() != len(info.Parameters) {
+		return nil, rpcErrorf(rpcInternalError, "metadata of %s lists %d parameters, the method takes %d", info.MethodName, len(info.Parameters), methodType.NumIn())
+	}
+
+	args, err := orderArgs(info, rawArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, raw := range args {
+		param := info.Parameters[i]
+		t := methodType.In(i)
+		switch t.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			return nil, rpcErrorf(rpcInvalidParams, "%s: parameter %s of type %s cannot be passed over RPC", info.MethodName, param.Name, param.Type)
+		}
+		value := reflect.New(t)
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return nil, rpcErrorf(rpcInvalidParams, "%s: parameter %s must be %s: %v", info.MethodName, param.Name, param.Type, err)
+		}
+		in[i] = value.Elem()
+	}
+
+	out, err := callRecovered(method, in)
+	if err != nil {
+		return nil, rpcErrorf(rpcInternalError, "%s: %v", info.MethodName, err)
+	}
+	if n := len(out); n > 0 && methodType.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, rpcErrorf(rpcCallFailed, "%s: %v", info.MethodName, err)
+		}
+		out = out[:n-1]
+	}
+
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out[0].Interface(), nil
+	}
+	results := make([]interface{}, len(out))
+	for i, v := range out {
+		results[i] = v.Interface()
+	}
+	return results, nil
+}
+
+// callRecovered calls method and turns a panic into an error, so a bad
+// argument cannot take the server down.
+func callRecovered(method reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return method.Call(in), nil
+}
+
+// orderArgs returns the raw arguments in parameter order. args is a list of
+// exactly as many values as the method has parameters, or an object naming
+// every parameter.
+func orderArgs(info blueprint.MethodInfo, args json.RawMessage) ([]json.RawMessage, error) {
+	want := len(info.Parameters)
+	if len(args) == 0 || string(args) == "null" {
+		if want > 0 {
+			return nil, rpcErrorf(rpcInvalidParams, "%s takes %d arguments: %s", info.MethodName, want, describeParams(info))
+		}
+		return nil, nil
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(args, &list); err == nil {
+		if len(list) != want {
+			return nil, rpcErrorf(rpcInvalidParams, "%s takes %d arguments, got %d: %s", info.MethodName, want, len(list), describeParams(info))
+		}
+		return list, nil
+	}
+
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(args, &named); err != nil {
+		return nil, rpcErrorf(rpcInvalidParams, "args must be a list or an object")
+	}
+	list = make([]json.RawMessage, want)
+	for i, param := range info.Parameters {
+		raw, ok := named[param.Name]
+		if !ok {
+			return nil, rpcErrorf(rpcInvalidParams, "%s: missing argument %s (%s)", info.MethodName, param.Name, param.Type)
+		}
+		list[i] = raw
+		delete(named, param.Name)
+	}
+	for name := range named {
+		return nil, rpcErrorf(rpcInvalidParams, "%s has no parameter %s: %s", info.MethodName, name, describeParams(info))
+	}
+	return list, nil
+}
+
+// describeParams lists the parameters of a method as "name type, ...".
+func describeParams(info blueprint.MethodInfo) string {
+	params := make([]string, len(info.Parameters))
+	for i, p := range info.Parameters {
+		params[i] = p.Name + " " + p.Type
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+// serveRPC runs the RPC server on addr until ctx is done.
+func serveRPC(ctx context.Context, addr string, server *RPCServer, ttl time.Duration) error {
+	if ttl > 0 {
+		go server.ExpireSessions(ctx, ttl)
+	}
+	log.Printf("Serving %d Blueprint methods at /rpc.", len(server.methods))
+	return listenAndServe(ctx, addr, server)
+}
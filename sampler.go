@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"blueprint"
+)
+
+// Samplers choosing the training sessions of each search round and
+// post-processing step.
+const (
+	samplerSequential = "sequential" // consecutive slices of the training set, wrapping around
+	samplerShuffled   = "shuffled"   // consecutive slices of a new permutation every epoch
+	samplerStratified = "stratified" // every class in proportion to its share of the training set
+	samplerBalanced   = "balanced"   // every class equally often, repeating rare ones
+	samplerHard       = "hard"       // sessions drawn in proportion to their last loss
+)
+
+// Sampler decides which samples of the training set round k trains on.
+// Samplers are deterministic in their seed and k, and in the weights a
+// feedbackSampler has learnt, which checkpoints keep, so a resumed run sees
+// the same batches.
+type Sampler interface {
+	// Sample returns the indices of the n samples of round k.
+	Sample(k, n int) []int
+}
+
+// feedbackSampler is a Sampler that learns from how the network did on the
+// samples of a round. Its weights are saved in checkpoints and restored on
+// resume.
+type feedbackSampler interface {
+	Sampler
+	Update(bp *blueprint.Blueprint, indices []int)
+	Weights() []float64
+	RestoreWeights(weights []float64) error
+}
+
+// newSampler creates the named sampler over src. classOutputs is needed by
+// the samplers that look at classes and may be nil otherwise.
+func newSampler(name string, src SessionSource, classOutputs []int, seed int64) (Sampler, error) {
+	total := src.Len()
+	if total == 0 {
+		return nil, fmt.Errorf("no training sessions to sample from")
+	}
+	switch name {
+	case "", samplerSequential:
+		return sequentialSampler{total: total}, nil
+	case samplerShuffled:
+		return shuffledSampler{total: total, seed: seed}, nil
+	case samplerHard:
+		return newHardExampleSampler(src, classOutputs, seed), nil
+	case samplerStratified, samplerBalanced:
+		if classOutputs == nil {
+			return nil, fmt.Errorf("sampler %q needs a classification dataset", name)
+		}
+		byClass := make([][]int, len(classOutputs))
+		for i := 0; i < total; i++ {
+			class := sessionClass(src, i, classOutputs)
+			byClass[class] = append(byClass[class], i)
+		}
+		return &classSampler{byClass: byClass, total: total, balanced: name == samplerBalanced, seed: seed}, nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", name)
+}
+
+// sequentialSampler hands out the training set in order.
+type sequentialSampler struct {
+	total int
+}
+
+func (s sequentialSampler) Sample(k, n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = (k*n + i) % s.total
+	}
+	return indices
+}
+
+// shuffledSampler hands out the training set in a random order that changes
+// every time the whole set has been seen.
+type shuffledSampler struct {
+	total int
+	seed  int64
+}
+
+func (s shuffledSampler) Sample(k, n int) []int {
+	indices := make([]int, n)
+	var perm []int
+	epoch := -1
+	for i := range indices {
+		pos := k*n + i
+		if e := pos / s.total; e != epoch {
+			epoch = e
+			perm = rand.New(rand.NewSource(s.seed + int64(epoch))).Perm(s.total)
+		}
+		indices[i] = perm[pos%s.total]
+	}
+	return indices
+}
+
+// classSampler draws a fixed number of samples per class each round, either
+// in proportion to the class sizes or the same number for every class. Each
+// class is walked through in a shuffled order.
+type classSampler struct {
+	byClass  [][]int
+	total    int
+	balanced bool
+	seed     int64
+}
+
+func (s *classSampler) Sample(k, n int) []int {
+	quotas := s.quotas(k, n)
+	indices := make([]int, 0, n)
+	for class, members := range s.byClass {
+		if len(members) == 0 || quotas[class] == 0 {
+			continue
+		}
+		// Round k starts k quotas into the class's order, so consecutive
+		// rounds walk through it without replaying earlier rounds
+		start := k * quotas[class]
+		order := rand.New(rand.NewSource(s.seed + int64(class))).Perm(len(members))
+		for i := 0; i < quotas[class]; i++ {
+			indices = append(indices, members[order[(start+i)%len(members)]])
+		}
+	}
+
+	// Mix the classes so the batch is not sorted by class
+	rng := rand.New(rand.NewSource(s.seed + int64(k)))
+	rng.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+	return indices
+}
+
+// quotas returns how many samples of each class round k takes. Remainders
+// go to the classes with the largest fractional share, rotating with k when
+// classes are balanced.
+func (s *classSampler) quotas(k, n int) []int {
+	quotas := make([]int, len(s.byClass))
+	if s.balanced {
+		var present []int
+		for class, members := range s.byClass {
+			if len(members) > 0 {
+				present = append(present, class)
+			}
+		}
+		if len(present) == 0 {
+			return quotas
+		}
+		for i, class := range present {
+			quotas[class] = n / len(present)
+			if (i-k%len(present)+len(present))%len(present) < n%len(present) {
+				quotas[class]++
+			}
+		}
+		return quotas
+	}
+
+	// Largest remainder apportionment of n over the class sizes
+	assigned := 0
+	remainders := make([]float64, len(s.byClass))
+	for class, members := range s.byClass {
+		share := float64(n) * float64(len(members)) / float64(s.total)
+		quotas[class] = int(share)
+		remainders[class] = share - math.Floor(share)
+		assigned += quotas[class]
+	}
+	for ; assigned < n; assigned++ {
+		best := 0
+		for class, r := range remainders {
+			if r > remainders[best] {
+				best = class
+			}
+		}
+		quotas[best]++
+		remainders[best] = -1
+	}
+	return quotas
+}
+
+// hardExampleSampler draws samples with probability proportional to the loss
+// the network last had on them, so samples it gets wrong come up more often.
+// Samples not yet seen count as hard as the hardest one seen.
+type hardExampleSampler struct {
+	src          SessionSource
+	classOutputs []int
+	weights      []float64
+	seen         []bool
+	seed         int64
+}
+
+// Bounds of the weights of the hard-example sampler. The floor keeps samples
+// the network already gets right in rotation; the cap stops a sample with an
+// infinite loss from crowding out all others.
+const (
+	hardExampleFloor = 0.01
+	hardExampleCap   = 100
+)
+
+func newHardExampleSampler(src SessionSource, classOutputs []int, seed int64) *hardExampleSampler {
+	return &hardExampleSampler{
+		src:          src,
+		classOutputs: classOutputs,
+		weights:      make([]float64, src.Len()),
+		seen:         make([]bool, src.Len()),
+		seed:         seed,
+	}
+}
+
+func (s *hardExampleSampler) Sample(k, n int) []int {
+	hardest := 1.0
+	for i, w := range s.weights {
+		if s.seen[i] {
+			hardest = math.Max(hardest, w)
+		}
+	}
+	cumulative := make([]float64, len(s.weights))
+	var sum float64
+	for i, w := range s.weights {
+		if !s.seen[i] {
+			w = hardest
+		}
+		sum += w
+		cumulative[i] = sum
+	}
+
+	rng := rand.New(rand.NewSource(s.seed + int64(k)))
+	indices := make([]int, n)
+	for i := range indices {
+		target := rng.Float64() * sum
+		lo, hi := 0, len(cumulative)-1
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if cumulative[mid] <= target {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		indices[i] = lo
+	}
+	return indices
+}
+
+// Weights returns the weight of every sample, 0 for those not yet seen.
+func (s *hardExampleSampler) Weights() []float64 {
+	return s.weights
+}
+
+// RestoreWeights continues from weights returned by Weights.
+func (s *hardExampleSampler) RestoreWeights(weights []float64) error {
+	if len(weights) != len(s.weights) {
+		return fmt.Errorf("the checkpoint has sampler weights for %d sessions, the training set has %d", len(weights), len(s.weights))
+	}
+	copy(s.weights, weights)
+	for i, w := range weights {
+		s.seen[i] = w > 0
+	}
+	return nil
+}
+
+// Update records the network's loss on the samples of the last round:
+// cross-entropy for classification, squared error otherwise.
+func (s *hardExampleSampler) Update(bp *blueprint.Blueprint, indices []int) {
+	for _, i := range indices {
+		session := s.src.Session(i)
+		bp.RunNetwork(session.InputVariables, session.Timesteps)
+		outputs := bp.GetOutputs()
+
+		var loss float64
+		if s.classOutputs != nil {
+			loss = CrossEntropy(classLogits(outputs, s.classOutputs), argmaxClass(session.ExpectedOutput, s.classOutputs))
+		} else {
+			for id, expected := range session.ExpectedOutput {
+				loss += (outputs[id] - expected) * (outputs[id] - expected)
+			}
+		}
+		if math.IsNaN(loss) {
+			loss = hardExampleCap
+		}
+		s.weights[i] = math.Min(math.Max(loss, hardExampleFloor), hardExampleCap)
+		s.seen[i] = true
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"blueprint"
+)
+
+// labelledSessions returns sessions whose expected class is labels[i], over
+// the output neurons 1 and 2.
+func labelledSessions(labels ...int) SessionList {
+	sessions := make(SessionList, len(labels))
+	for i, label := range labels {
+		expected := map[int]float64{1: 0, 2: 0}
+		expected[label+1] = 1
+		sessions[i] = blueprint.Session{InputVariables: map[int]float64{}, ExpectedOutput: expected, Timesteps: 1}
+	}
+	return sessions
+}
+
+func TestSamplersAreDeterministic(t *testing.T) {
+	sessions := labelledSessions(0, 0, 0, 0, 0, 0, 1, 1, 1, 1)
+	for _, name := range []string{samplerSequential, samplerShuffled, samplerStratified, samplerBalanced, samplerHard} {
+		t.Run(name, func(t *testing.T) {
+			a, err := newSampler(name, sessions, []int{1, 2}, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := newSampler(name, sessions, []int{1, 2}, 7)
+			for k := 0; k < 5; k++ {
+				first, second := a.Sample(k, 4), b.Sample(k, 4)
+				if len(first) != 4 || !reflect.DeepEqual(first, second) {
+					t.Fatalf("round %d: got %v and %v from the same seed", k, first, second)
+				}
+				for _, i := range first {
+					if i < 0 || i >= sessions.Len() {
+						t.Fatalf("round %d: index %d out of range", k, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSequentialSamplerWraps(t *testing.T) {
+	s := sequentialSampler{total: 5}
+	tests := []struct {
+		k, n int
+		want []int
+	}{
+		{0, 3, []int{0, 1, 2}},
+		{1, 3, []int{3, 4, 0}},
+		{0, 7, []int{0, 1, 2, 3, 4, 0, 1}},
+	}
+	for _, tt := range tests {
+		if got := s.Sample(tt.k, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Sample(%d, %d) = %v, want %v", tt.k, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestShuffledSamplerCoversEveryEpoch(t *testing.T) {
+	s := shuffledSampler{total: 6, seed: 3}
+	seen := make(map[int]bool)
+	for k := 0; k < 3; k++ {
+		for _, i := range s.Sample(k, 2) {
+			seen[i] = true
+		}
+	}
+	if len(seen) != 6 {
+		t.Errorf("one epoch covered %d of 6 samples", len(seen))
+	}
+}
+
+func TestClassSamplerQuotas(t *testing.T) {
+	sessions := labelledSessions(0, 0, 0, 0, 0, 0, 1, 1, 1, 1)
+	tests := []struct {
+		name string
+		want [2]int // samples of each class in a batch of 5
+	}{
+		{samplerStratified, [2]int{3, 2}},
+		{samplerBalanced, [2]int{3, 2}}, // the remainder rotates with k; round 0 gives it to class 0
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSampler(tt.name, sessions, []int{1, 2}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [2]int
+			for _, i := range s.Sample(0, 5) {
+				got[sessionClass(sessions, i, []int{1, 2})]++
+			}
+			if got != tt.want {
+				t.Errorf("class counts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	s, _ := newSampler(samplerBalanced, sessions, []int{1, 2}, 1)
+	var got [2]int
+	for _, i := range s.Sample(1, 5) {
+		got[sessionClass(sessions, i, []int{1, 2})]++
+	}
+	if got != [2]int{2, 3} {
+		t.Errorf("balanced round 1 class counts = %v, want [2 3]", got)
+	}
+}
+
+func TestClassSamplerNeedsClasses(t *testing.T) {
+	if _, err := newSampler(samplerStratified, labelledSessions(0, 1), nil, 1); err == nil {
+		t.Error("stratified sampler without classes: want an error")
+	}
+	if _, err := newSampler("random", labelledSessions(0, 1), nil, 1); err == nil {
+		t.Error("unknown sampler: want an error")
+	}
+}
+
+func TestHardExampleSamplerRestoresWeights(t *testing.T) {
+	sessions := labelledSessions(0, 1, 0, 1)
+	original := newHardExampleSampler(sessions, []int{1, 2}, 5)
+	copy(original.weights, []float64{hardExampleFloor, hardExampleCap, 0, 2})
+	copy(original.seen, []bool{true, true, false, true})
+
+	resumed := newHardExampleSampler(sessions, []int{1, 2}, 5)
+	if err := resumed.RestoreWeights(original.Weights()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed.seen, original.seen) {
+		t.Errorf("restored seen = %v, want %v", resumed.seen, original.seen)
+	}
+	for k := 0; k < 3; k++ {
+		if a, b := original.Sample(k, 20), resumed.Sample(k, 20); !reflect.DeepEqual(a, b) {
+			t.Fatalf("round %d: resumed sampler drew %v, want %v", k, b, a)
+		}
+	}
+
+	if err := resumed.RestoreWeights([]float64{1}); err == nil {
+		t.Error("restoring weights for another training set: want an error")
+	}
+}
+
+func TestHardExampleSamplerFavoursHardSamples(t *testing.T) {
+	sessions := labelledSessions(0, 1, 0)
+	s := newHardExampleSampler(sessions, []int{1, 2}, 9)
+	// Sample 2 is unseen, so it counts as hard as sample 1
+	copy(s.weights, []float64{hardExampleFloor, hardExampleCap, 0})
+	copy(s.seen, []bool{true, true, false})
+
+	counts := make([]int, 3)
+	for _, i := range s.Sample(0, 2000) {
+		counts[i]++
+	}
+	if counts[0] > 40 || counts[1] < 800 || counts[2] < 800 {
+		t.Errorf("draws per sample = %v, want few of sample 0 and about as many of 1 as of 2", counts)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"blueprint"
+)
+
+// Scenario describes an example that can be started with "hammer run <name>".
+type Scenario struct {
+	Name        string
+	Description string
+	// Setup registers the scenario's flags on fs and returns the function that
+	// runs the scenario once the flags have been parsed.
+	Setup func(fs *flag.FlagSet) func(rc *RunContext) error
+	// Interruptible scenarios stop cleanly when RunContext.Context is
+	// cancelled. Only they get a SIGINT handler; Ctrl-C ends the others
+	// immediately.
+	Interruptible bool
+}
+
+// RunContext carries the settings shared by every scenario run and collects
+// the network the scenario ends up with.
+type RunContext struct {
+	// Context is cancelled when the run is interrupted.
+	Context  context.Context
+	Scenario string
+	Seed     int64
+
+	// Blueprint is the network created through NewBlueprint, if any.
+	Blueprint *blueprint.Blueprint
+}
+
+// NewBlueprint creates the scenario's network and records it as the run's result.
+func (rc *RunContext) NewBlueprint() *blueprint.Blueprint {
+	rc.Blueprint = blueprint.NewBlueprint()
+	return rc.Blueprint
+}
+
+// Metadata describes the run for inclusion in saved models.
+func (rc *RunContext) Metadata() ModelMetadata {
+	return ModelMetadata{
+		Scenario: rc.Scenario,
+		Seed:     rc.Seed,
+	}
+}
+
+// runScenario seeds the random number generator and runs the scenario once.
+func runScenario(ctx context.Context, name string, seed int64, run func(rc *RunContext) error) (*RunContext, error) {
+	log.Printf("Running scenario %s with seed %d", name, seed)
+	rand.Seed(seed)
+
+	rc := &RunContext{Context: ctx, Scenario: name, Seed: seed}
+	return rc, run(rc)
+}
+
+// scenarios is the registry of every scenario known to the CLI.
+var scenarios = []Scenario{
+	{
+		Name:        "simple",
+		Description: "Run a small hand-written network with every classical neuron type",
+		Setup:       noFlags(simple1),
+	},
+	{
+		Name:        "quantum",
+		Description: "Process quantum neurons, optionally feeding them into a classical network",
+		Setup: variants("basic", map[string]func(rc *RunContext) error{
+			"basic":       RunQuantumExample,
+			"integration": RunQuantumExampleWithIntegration,
+		}),
+	},
+	{
+		Name:        "nca",
+		Description: "Run networks built around neuro cellular automata neurons",
+		Setup: variants("basic", map[string]func(rc *RunContext) error{
+			"basic":       testNeuroCellularAutomata,
+			"full-range":  testFullRangeOfNeuronsNCA,
+			"cnn-kernels": testNeuroCellularAutomataWithCNNKernels,
+		}),
+	},
+	{
+		Name:        "mutations",
+		Description: "Mutate a small network by inserting neurons between inputs and outputs",
+		Setup: variants("lstm", map[string]func(rc *RunContext) error{
+			"lstm":           testMutations,
+			"multiple-types": testMutationsWithMultipleTypes,
+			"all-types":      testAllNeuronTypes,
+		}),
+	},
+	{
+		Name:        "nas",
+		Description: "Search for an architecture that fits a tiny regression dataset",
+		Setup: variants("crossover", map[string]func(rc *RunContext) error{
+			"crossover":          simpleNAS,
+			"without-crossover":  simpleNASWithoutCrossover,
+			"random-connections": testWithRandomConnections,
+		}),
+	},
+	{
+		Name:        "introspection",
+		Description: "Print the Blueprint method metadata",
+		Setup:       noFlags(testBlueprintMethods),
+	},
+	{
+		Name:        "save-all-types",
+		Description: "Build a network with one neuron of each type and save it as JSON",
+		Setup: func(fs *flag.FlagSet) func(rc *RunContext) error {
+			destination := fs.String("out", "output/test.json", "file to write the network to")
+			return func(rc *RunContext) error {
+				return createAndSaveAllNeuronTypesToFile(rc, *destination)
+			}
+		},
+	},
+	{
+		Name:          "mnist",
+		Description:   "Download MNIST and train a network on it with AdvancedParallelNAS",
+		Interruptible: true,
+		Setup: func(fs *flag.FlagSet) func(rc *RunContext) error {
+			return imageClassifySetup(fs, func() string { return "mnist" })
+		},
+	},
+	{
+		Name:          "image-classify",
+		Description:   "Train a network on a registered image dataset (mnist, fashion-mnist, kmnist, emnist-*, cifar-10)",
+		Interruptible: true,
+		Setup: func(fs *flag.FlagSet) func(rc *RunContext) error {
+			dataset := fs.String("dataset", "mnist", "image dataset to train on: "+strings.Join(imageDatasetNames(), ", "))
+			return imageClassifySetup(fs, func() string { return *dataset })
+		},
+	},
+	{
+		Name:          "sequence",
+		Description:   "Train on a synthetic sequence task (sine, copy, parity) to see whether recurrent neurons learn it",
+		Interruptible: true,
+		Setup: func(fs *flag.FlagSet) func(rc *RunContext) error {
+			task := fs.String("task", sequenceSine, "sequence task: sine, copy or parity")
+			window := fs.Int("window", defaultSequenceWindow, "steps per session")
+			samples := fs.Int("samples", defaultSequenceSamples, "training sessions to generate")
+			iterations := fs.Int("iterations", DefaultSequenceExperiment(sequenceSine).Search.MaxIterations, "hill-climbing iterations (mutations tried)")
+			neuronTypes := fs.String("neuron-types", "dense,rnn,lstm", "comma-separated neuron types the search may add (e.g. \"dense\" for a baseline without recurrence)")
+			return func(rc *RunContext) error {
+				if *task == sequenceSeries {
+					return usageErrorf("the series task reads a file, use an experiment file for it")
+				}
+				exp := DefaultSequenceExperiment(*task)
+				exp.Dataset.Sequence.Window = *window
+				exp.Dataset.Sequence.Samples = *samples
+				exp.Dataset.Sequence.Seed = rc.Seed
+				exp.Search.MaxIterations = *iterations
+				exp.Search.NeuronTypes = strings.Split(*neuronTypes, ",")
+				if err := exp.Validate(); err != nil {
+					return usageErrorf("%v", err)
+				}
+				return runExperiment(rc, exp)
+			}
+		},
+	},
+	{
+		Name:          "experiment",
+		Description:   "Run the NAS experiment described by a JSON experiment file",
+		Interruptible: true,
+		Setup: func(fs *flag.FlagSet) func(rc *RunContext) error {
+			config := fs.String("config", "", "experiment file to run (required unless resuming)")
+			resume := fs.String("resume", "", "continue the run saved in this checkpoint file")
+			return func(rc *RunContext) error {
+				if *resume != "" {
+					return resumeExperiment(rc, *resume)
+				}
+				if *config == "" {
+					return usageErrorf("--config is required")
+				}
+				exp, err := LoadExperiment(*config)
+				if err != nil {
+					return err
+				}
+				return runExperiment(rc, exp)
+			}
+		},
+	},
+}
+
+// imageClassifySetup registers the training flags of the image
+// classification scenarios. dataset returns the dataset to train on once the
+// flags have been parsed.
+func imageClassifySetup(fs *flag.FlagSet, dataset func() string) func(rc *RunContext) error {
+	var (
+		source     string
+		exportPNG  bool
+		grayscale  bool
+		stopping   = DefaultEarlyStoppingOptions()
+		batchSize  = DefaultMNISTExperiment().Search.SessionBatchSize
+		sampler    string
+		checkpoint string
+		augment    AugmentConfig
+		preprocess string
+		components int
+	)
+	fs.StringVar(&source, "data-source", "", "base URL, file:// URL or directory holding the archives, or \"synthetic\" (default $"+datasetSourceEnv+" or the public mirror)")
+	fs.BoolVar(&exportPNG, "export-png", false, "also write every training image as a PNG for debugging")
+	fs.BoolVar(&grayscale, "grayscale", false, "convert colour images to one luminance input per pixel")
+	fs.Float64Var(&stopping.ValidationFraction, "val-split", stopping.ValidationFraction, "fraction of each class held out for validation, in [0, 1) (0 disables early stopping)")
+	fs.IntVar(&stopping.RoundIterations, "round-iterations", stopping.RoundIterations, "NAS iterations between validation passes")
+	fs.IntVar(&stopping.Patience, "patience", stopping.Patience, "validation rounds without improvement before stopping (at least 1)")
+	fs.Float64Var(&stopping.MinDelta, "min-delta", stopping.MinDelta, "smallest validation accuracy gain that counts as improvement")
+	fs.IntVar(&batchSize, "session-batch-size", batchSize, "training sessions built per search round (0 builds the whole training set at once)")
+	fs.IntVar(&augment.MaxShift, "augment-shift", 0, "shift training images by up to this many pixels")
+	fs.Float64Var(&augment.MaxRotation, "augment-rotation", 0, "rotate training images by up to this many degrees")
+	fs.Float64Var(&augment.Elastic, "augment-elastic", 0, "strength in pixels of elastic distortion of training images")
+	fs.Float64Var(&augment.Noise, "augment-noise", 0, "standard deviation of gaussian noise added to training pixels")
+	fs.IntVar(&augment.Cutout, "augment-cutout", 0, "side of a square blanked at a random position of each training image")
+	fs.Int64Var(&augment.Seed, "augment-seed", 0, "seed of the augmentation (0 uses the run's seed)")
+	fs.StringVar(&sampler, "sampler", samplerSequential, "how each batch is drawn: sequential, shuffled, stratified, balanced or hard")
+	fs.StringVar(&preprocess, "preprocess", "", "comma-separated preprocessing steps fitted on the training images: minmax, zscore, mean, pca-whiten")
+	fs.IntVar(&components, "pca-components", defaultPCAComponents, "principal components kept by pca-whiten")
+	fs.StringVar(&checkpoint, "checkpoint", "", "file to checkpoint progress to (default <dataset>/checkpoint.json, empty disables checkpoints)")
+	resume := fs.String("resume", "", "continue the run saved in this checkpoint file")
+
+	return func(rc *RunContext) error {
+		if *resume != "" {
+			return resumeExperiment(rc, *resume)
+		}
+
+		ds, err := findImageDataset(dataset())
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		exp := DefaultImageExperiment(ds)
+		exp.Dataset.Source = source
+		exp.Dataset.ExportPNG = exportPNG
+		exp.Dataset.Grayscale = grayscale
+		if stopping.ValidationFraction > 0 {
+			exp.Search.EarlyStopping = &stopping
+		}
+		exp.Search.SessionBatchSize = batchSize
+		exp.Search.Sampler = sampler
+		if augment.Enabled() {
+			if err := augment.Validate(); err != nil {
+				return usageErrorf("%v", err)
+			}
+			exp.Dataset.Augment = &augment
+		}
+		if preprocess != "" {
+			for _, kind := range strings.Split(preprocess, ",") {
+				exp.Dataset.Preprocess = append(exp.Dataset.Preprocess, PreprocessStep{Kind: kind, Components: components})
+			}
+		}
+		if err := exp.Validate(); err != nil {
+			return usageErrorf("%v", err)
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "checkpoint" {
+				exp.Checkpoint = checkpoint
+			}
+		})
+		return runExperiment(rc, exp)
+	}
+}
+
+// findScenario looks up a registered scenario by name.
+func findScenario(name string) (Scenario, bool) {
+	for _, s := range scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// noFlags adapts a scenario function that takes no options.
+func noFlags(run func(rc *RunContext) error) func(fs *flag.FlagSet) func(rc *RunContext) error {
+	return func(fs *flag.FlagSet) func(rc *RunContext) error {
+		return run
+	}
+}
+
+// variants builds a scenario that selects one of several functions with --variant.
+func variants(defaultVariant string, runs map[string]func(rc *RunContext) error) func(fs *flag.FlagSet) func(rc *RunContext) error {
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(fs *flag.FlagSet) func(rc *RunContext) error {
+		variant := fs.String("variant", defaultVariant, "variant to run: "+strings.Join(names, ", "))
+		return func(rc *RunContext) error {
+			run, ok := runs[*variant]
+			if !ok {
+				return usageErrorf("unknown variant %q (available: %s)", *variant, strings.Join(names, ", "))
+			}
+			return run(rc)
+		}
+	}
+}
+
+// listScenarios prints every registered scenario along with its flags.
+func listScenarios() {
+	for _, s := range scenarios {
+		fmt.Printf("%-16s %s\n", s.Name, s.Description)
+
+		fs := flag.NewFlagSet(s.Name, flag.ContinueOnError)
+		s.Setup(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Printf("%-16s   --%s (default %q): %s\n", "", f.Name, f.DefValue, f.Usage)
+		})
+	}
+}
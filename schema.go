@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"blueprint"
+)
+
+// jsonSchema is a JSON Schema (draft 2020-12) object.
+type jsonSchema = map[string]interface{}
+
+// jsonSchemaDialect is the draft the generated schemas follow; OpenAPI 3.1
+// uses the same one.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// neuronConfigName names the schema of the JSON LoadNeurons reads.
+const neuronConfigName = "NeuronConfig"
+
+// Neuron types, activations and update rules used by the scenarios. The
+// neuron config schema offers them as examples rather than an enum, since
+// the framework may know more.
+var (
+	knownNeuronTypes = []string{"input", "output", "dense", "rnn", "lstm", "cnn", "nca", "attention", "dropout", "batch_norm"}
+	knownActivations = []string{"linear", "relu", "leaky_relu", "sigmoid", "tanh"}
+	knownUpdateRules = []string{"sum", "average"}
+)
+
+// stringContentRefs names the schema of the JSON document held by the string
+// parameters of some Blueprint methods.
+var stringContentRefs = map[string]string{
+	"LoadNeurons": neuronConfigName,
+}
+
+// neuronType is the type LoadNeurons decodes each neuron of its JSON into.
+var neuronType = reflect.TypeOf(blueprint.Neuron{})
+
+// neuronConfigSchema describes the JSON LoadNeurons reads: a list of neurons
+// with the fields of blueprint.Neuron. Fields it does not have are allowed,
+// as LoadNeurons ignores them; hammer lint reports them instead.
+func (g *schemaGenerator) neuronConfigSchema() (jsonSchema, error) {
+	neuron, err := g.structSchema(neuronType)
+	if err != nil {
+		return nil, err
+	}
+	neuron["required"] = []string{"id", "type"}
+
+	annotations := map[string]jsonSchema{
+		"id":         {"description": "Neuron ID, unique in the network."},
+		"type":       {"examples": knownNeuronTypes},
+		"value":      {"description": "Initial activation."},
+		"activation": {"examples": knownActivations},
+		"connections": {
+			"description": "Incoming connections as [source neuron ID, weight] pairs.",
+			"items": jsonSchema{
+				"type":        "array",
+				"prefixItems": []jsonSchema{{"type": "integer"}, {"type": "number"}},
+				"minItems":    2,
+				"maxItems":    2,
+			},
+		},
+		"neighborhood": {"description": "Neighbours of an nca neuron."},
+		"update_rules": {"description": "How an nca neuron combines its neighbours.", "examples": knownUpdateRules},
+		"kernels":      {"description": "Convolution kernels of a cnn neuron."},
+		"dropout_rate": {"minimum": 0, "maximum": 1},
+	}
+	properties := neuron["properties"].(jsonSchema)
+	for name, extra := range annotations {
+		property, ok := properties[name].(jsonSchema)
+		if !ok {
+			continue
+		}
+		for k, v := range extra {
+			property[k] = v
+		}
+	}
+
+	return jsonSchema{
+		"title":       neuronConfigName,
+		"description": "Neuron configuration read by LoadNeurons.",
+		"type":        "array",
+		"items":       neuron,
+	}, nil
+}
+
+// schemaGenerator turns Go types into JSON Schemas. Named struct types are
+// defined once in defs and referenced as refPrefix+name.
+type schemaGenerator struct {
+	refPrefix string
+	defs      map[string]jsonSchema
+	skipped   map[string]string // methods left out, with the reason
+}
+
+// newSchemaGenerator creates a generator whose definitions start with the
+// neuron config schema.
+func newSchemaGenerator(refPrefix string) *schemaGenerator {
+	g := &schemaGenerator{
+		refPrefix: refPrefix,
+		defs:      make(map[string]jsonSchema),
+		skipped:   make(map[string]string),
+	}
+	neurons, err := g.neuronConfigSchema()
+	if err != nil {
+		g.skipped[neuronConfigName] = err.Error()
+	} else {
+		g.defs[neuronConfigName] = neurons
+	}
+	return g
+}
+
+// ref returns a schema referring to the named definition.
+func (g *schemaGenerator) ref(name string) jsonSchema {
+	return jsonSchema{"$ref": g.refPrefix + name}
+}
+
+// typeSchema returns the schema of values of t as encoding/json writes them.
+// Pointers, slices and maps may be null, as a nil one is written. Functions,
+// channels and complex numbers have no JSON form.
+func (g *schemaGenerator) typeSchema(t reflect.Type) (jsonSchema, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return jsonSchema{"type": "integer", "description": "Duration in nanoseconds."}, nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"anyOf": []jsonSchema{s, {"type": "null"}}}, nil
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return jsonSchema{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return jsonSchema{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}, nil
+	case reflect.String:
+		return jsonSchema{"type": "string"}, nil
+	case reflect.Interface:
+		return jsonSchema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind() == reflect.Array {
+			return jsonSchema{"type": "array", "items": items, "minItems": t.Len(), "maxItems": t.Len()}, nil
+		}
+		return jsonSchema{"type": []string{"array", "null"}, "items": items}, nil
+	case reflect.Map:
+		values, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s := jsonSchema{"type": []string{"object", "null"}, "additionalProperties": values}
+		switch t.Key().Kind() {
+		case reflect.String:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s["propertyNames"] = jsonSchema{"pattern": "^-?[0-9]+$"}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s["propertyNames"] = jsonSchema{"pattern": "^[0-9]+$"}
+		default:
+			return nil, fmt.Errorf("map key type %s has no JSON form", t.Key())
+		}
+		return s, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder for recursive types
+			s, err := g.structSchema(t)
+			if err != nil {
+				delete(g.defs, t.Name())
+				return nil, err
+			}
+			s["title"] = t.Name()
+			g.defs[t.Name()] = s
+		}
+		return g.ref(t.Name()), nil
+	}
+	return nil, fmt.Errorf("type %s has no JSON form", t)
+}
+
+// jsonFieldName returns the name encoding/json gives a struct field, or false
+// if it leaves the field out.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// structSchema describes the exported fields of a struct under their JSON
+// names.
+func (g *schemaGenerator) structSchema(t reflect.Type) (jsonSchema, error) {
+	properties := jsonSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		s, err := g.typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		properties[name] = s
+	}
+	return jsonSchema{"type": "object", "properties": properties}, nil
+}
+
+// methodSchemas are the schemas of one Blueprint method's arguments and
+// result.
+type methodSchemas struct {
+	info   blueprint.MethodInfo
+	args   jsonSchema // the "args" member of an RPC call: a list or an object by name
+	result jsonSchema
+}
+
+// describeMethod builds the schemas of a method from its metadata and its Go
+// signature. Parameters are named as GetBlueprintMethods reports them; a
+// trailing error result is left out, as callMethod turns it into an RPC
+// error.
+func (g *schemaGenerator) describeMethod(info blueprint.MethodInfo, method reflect.Type) (methodSchemas, error) {
+	if method.NumIn() != len(info.Parameters) {
+		return methodSchemas{}, fmt.Errorf("metadata lists %d parameters, the method takes %d", len(info.Parameters), method.NumIn())
+	}
+
+	items := make([]jsonSchema, len(info.Parameters))
+	properties := jsonSchema{}
+	names := make([]string, len(info.Parameters))
+	for i, param := range info.Parameters {
+		s, err := g.typeSchema(method.In(i))
+		if err != nil {
+			return methodSchemas{}, fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+		if content, ok := stringContentRefs[info.MethodName]; ok && g.defs[content] != nil && method.In(i).Kind() == reflect.String {
+			s["contentMediaType"] = "application/json"
+			s["contentSchema"] = g.ref(content)
+		}
+		s["description"] = "Go type " + param.Type
+		items[i] = s
+		properties[param.Name] = s
+		names[i] = param.Name
+	}
+	args := jsonSchema{"oneOf": []jsonSchema{
+		{"type": "array", "prefixItems": items, "minItems": len(items), "maxItems": len(items)},
+		{"type": "object", "properties": properties, "required": names, "additionalProperties": false},
+	}}
+
+	var results []jsonSchema
+	for i := 0; i < method.NumOut(); i++ {
+		if i == method.NumOut()-1 && method.Out(i) == errorType {
+			break
+		}
+		s, err := g.typeSchema(method.Out(i))
+		if err != nil {
+			return methodSchemas{}, fmt.Errorf("result %d: %w", i+1, err)
+		}
+		results = append(results, s)
+	}
+	var result jsonSchema
+	switch len(results) {
+	case 0:
+		result = jsonSchema{"type": "null"}
+	case 1:
+		result = results[0]
+	default:
+		result = jsonSchema{"type": "array", "prefixItems": results, "minItems": len(results), "maxItems": len(results)}
+	}
+	return methodSchemas{info: info, args: args, result: result}, nil
+}
+
+// describeMethods describes every Blueprint method that can be called over
+// JSON, sorted by name. Methods whose parameters or results have no JSON
+// form are recorded in skipped instead.
+func (g *schemaGenerator) describeMethods(infos []blueprint.MethodInfo) []methodSchemas {
+	bp := reflect.ValueOf(blueprint.NewBlueprint())
+	var methods []methodSchemas
+	for _, info := range infos {
+		method := bp.MethodByName(info.MethodName)
+		if !method.IsValid() {
+			g.skipped[info.MethodName] = "no such method"
+			continue
+		}
+		m, err := g.describeMethod(info, method.Type())
+		if err != nil {
+			g.skipped[info.MethodName] = err.Error()
+			continue
+		}
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].info.MethodName < methods[j].info.MethodName })
+	return methods
+}
+
+// GenerateOpenAPI describes the JSON-RPC endpoint of "hammer rpc" as an
+// OpenAPI 3.1 document. Every call is a request schema named after its
+// method, selected by the "method" member; its result schema is linked as
+// x-result. Methods that cannot be called over JSON are left out with a log
+// message.
+func GenerateOpenAPI(infos []blueprint.MethodInfo) jsonSchema {
+	g := newSchemaGenerator("#/components/schemas/")
+	methods := g.describeMethods(infos)
+	for name, reason := range g.skipped {
+		log.Printf("Leaving out %s: %s", name, reason)
+	}
+
+	schemas := jsonSchema{}
+	var variants []jsonSchema
+	mapping := map[string]string{}
+	addCall := func(method, description string, params, result jsonSchema) {
+		name := method + ".Request"
+		schemas[name] = jsonSchema{
+			"description": description,
+			"type":        "object",
+			"required":    []string{"jsonrpc", "method"},
+			"properties": jsonSchema{
+				"jsonrpc": jsonSchema{"const": "2.0"},
+				"id":      g.ref("RPCID"),
+				"method":  jsonSchema{"const": method},
+				"params":  params,
+			},
+			"x-result": result,
+		}
+		variants = append(variants, g.ref(name))
+		mapping[method] = g.refPrefix + name
+	}
+
+	session := jsonSchema{"type": "string", "description": "Session ID returned by " + rpcCreateSession + "."}
+	addCall(rpcCreateSession, "Starts a session with an empty Blueprint, or the saved model at model.",
+		jsonSchema{"type": "object", "properties": jsonSchema{"model": jsonSchema{"type": "string"}}},
+		jsonSchema{"type": "object", "required": []string{"session"}, "properties": jsonSchema{"session": session}})
+	addCall(rpcCloseSession, "Ends a session.",
+		jsonSchema{"type": "object", "required": []string{"session"}, "properties": jsonSchema{"session": session}},
+		jsonSchema{"const": true})
+	methodInfo, _ := g.typeSchema(reflect.TypeOf(blueprint.MethodInfo{}))
+	addCall(rpcListMethods, "Lists the Blueprint methods and their parameters.",
+		jsonSchema{"type": "object"},
+		jsonSchema{"type": "array", "items": methodInfo})
+
+	for _, m := range methods {
+		params := jsonSchema{
+			"type":       "object",
+			"required":   []string{"session"},
+			"properties": jsonSchema{"session": session, "args": m.args},
+		}
+		if len(m.info.Parameters) > 0 {
+			params["required"] = []string{"session", "args"}
+		}
+		addCall(m.info.MethodName, "Calls Blueprint."+m.info.MethodName+describeParams(m.info)+".", params, m.result)
+	}
+
+	schemas["RPCID"] = jsonSchema{"type": []string{"string", "integer", "null"}, "description": "Omitted in notifications."}
+	schemas["RPCRequest"] = jsonSchema{
+		"oneOf":         variants,
+		"discriminator": jsonSchema{"propertyName": "method", "mapping": mapping},
+	}
+	schemas["RPCError"] = jsonSchema{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": jsonSchema{
+			"code": jsonSchema{
+				"type": "integer",
+				"description": fmt.Sprintf("%d parse error, %d invalid request, %d unknown method, %d invalid params, %d internal error, %d the method returned an error, %d unknown session.",
+					rpcParseError, rpcInvalidRequest, rpcMethodNotFound, rpcInvalidParams, rpcInternalError, rpcCallFailed, rpcUnknownSession),
+			},
+			"message": jsonSchema{"type": "string"},
+		},
+	}
+	schemas["RPCResponse"] = jsonSchema{
+		"type":     "object",
+		"required": []string{"jsonrpc", "id"},
+		"properties": jsonSchema{
+			"jsonrpc": jsonSchema{"const": "2.0"},
+			"id":      g.ref("RPCID"),
+			"result":  jsonSchema{"description": "The x-result of the request's schema."},
+			"error":   g.ref("RPCError"),
+		},
+	}
+	for name, s := range g.defs {
+		schemas[name] = s
+	}
+
+	batch := func(name string) jsonSchema {
+		return jsonSchema{"oneOf": []jsonSchema{g.ref(name), {"type": "array", "minItems": 1, "items": g.ref(name)}}}
+	}
+	return jsonSchema{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": jsonSchemaDialect,
+		"info": jsonSchema{
+			"title":       "hammer JSON-RPC",
+			"version":     "1.0.0",
+			"description": "JSON-RPC 2.0 calls to Blueprint methods on session-scoped networks, served by hammer rpc.",
+		},
+		"servers":  []jsonSchema{{"url": "http://127.0.0.1:8090"}},
+		"security": []jsonSchema{{"bearerToken": []string{}}},
+		"paths": jsonSchema{
+			"/rpc": jsonSchema{
+				"post": jsonSchema{
+					"operationId": "call",
+					"summary":     "Make one call or a batch of calls",
+					"requestBody": jsonSchema{
+						"required": true,
+						"content":  jsonSchema{"application/json": jsonSchema{"schema": batch("RPCRequest")}},
+					},
+					"responses": jsonSchema{
+						"200": jsonSchema{
+							"description": "The response to each call that has an id.",
+							"content":     jsonSchema{"application/json": jsonSchema{"schema": batch("RPCResponse")}},
+						},
+						"204": jsonSchema{"description": "Only notifications were sent."},
+						"401": jsonSchema{"description": "The bearer token is missing or wrong."},
+						"403": jsonSchema{"description": "The request came from a page of another origin."},
+						"415": jsonSchema{"description": "The body is not sent as application/json."},
+					},
+				},
+			},
+		},
+		"components": jsonSchema{
+			"schemas": schemas,
+			"securitySchemes": jsonSchema{
+				"bearerToken": jsonSchema{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The token hammer rpc prints at startup, or the one set with --token or $" + rpcTokenEnv + ".",
+				},
+			},
+		},
+	}
+}
+
+// WriteJSONSchemas writes standalone JSON Schemas into dir: one per shared
+// type, such as NeuronConfig.schema.json and Session.schema.json, and one
+// per method for its "args", such as RunNetwork.args.schema.json. Each file
+// carries the definitions it may refer to. Methods are left out like in
+// GenerateOpenAPI.
+func WriteJSONSchemas(dir string, infos []blueprint.MethodInfo) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	g := newSchemaGenerator("#/$defs/")
+	methods := g.describeMethods(infos)
+
+	var written []string
+	write := func(name string, s jsonSchema) error {
+		doc := jsonSchema{"$schema": jsonSchemaDialect, "$id": name}
+		for k, v := range s {
+			doc[k] = v
+		}
+		doc["$defs"] = g.defs
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+		return nil
+	}
+
+	for _, m := range methods {
+		s := jsonSchema{"title": m.info.MethodName + " arguments", "oneOf": m.args["oneOf"]}
+		if err := write(m.info.MethodName+".args.schema.json", s); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(g.defs))
+	for name := range g.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := write(name+".schema.json", g.ref(name)); err != nil {
+			return nil, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"blueprint"
+)
+
+// Sequence tasks.
+const (
+	sequenceSeries = "series" // sliding windows over the rows of a CSV or TSV file
+	sequenceSine   = "sine"   // predict the next sample of a sine wave
+	sequenceCopy   = "copy"   // reproduce the bits shown before a blank stretch
+	sequenceParity = "parity" // classify whether a bit string has an odd number of ones
+)
+
+// Defaults of synthetic sequence tasks.
+const (
+	defaultSequenceWindow  = 8
+	defaultSequenceSamples = 500
+)
+
+// SequenceConfig describes a dataset of fixed-length windows over a sequence.
+//
+// A window is fed one step per RunNetwork call: feature f of every step goes
+// to input neuron 1 + f, recurrent neurons carry their state from one step
+// to the next, and the outputs, numbered after the inputs, are read after
+// the last step. The Blueprint search methods run each session with a
+// single input map, so sequence datasets are trained by
+// SequenceHillClimbing, which feeds windows the same way.
+type SequenceConfig struct {
+	Task string `json:"task"`
+	// Window is the number of steps per session (default 8).
+	Window int `json:"window,omitempty"`
+
+	// Path, Delimiter, Features and Targets describe the file of a "series"
+	// task; targets default to the features. Horizon is how many rows after
+	// the end of the window the targets are read from (default 1). The last
+	// TestFraction of the windows is held out for evaluation (default 0.2).
+	Path         string   `json:"path,omitempty"`
+	Delimiter    string   `json:"delimiter,omitempty"`
+	Features     []string `json:"features,omitempty"`
+	Targets      []string `json:"targets,omitempty"`
+	Horizon      int      `json:"horizon,omitempty"`
+	TestFraction float64  `json:"test_fraction,omitempty"`
+
+	// Samples is the number of training sessions of a synthetic task
+	// (default 500); a fifth as many are generated for testing. Seed fixes
+	// the generated data.
+	Samples int   `json:"samples,omitempty"`
+	Seed    int64 `json:"seed,omitempty"`
+}
+
+// Validate checks the task and the options it needs.
+func (c SequenceConfig) Validate() error {
+	switch c.Task {
+	case sequenceSeries:
+		if c.Path == "" {
+			return fmt.Errorf("series task needs a path")
+		}
+	case sequenceSine, sequenceCopy, sequenceParity:
+	default:
+		return fmt.Errorf("unknown sequence task %q", c.Task)
+	}
+	if c.Window < 0 || c.Horizon < 0 || c.Samples < 0 {
+		return fmt.Errorf("window, horizon and samples must not be negative")
+	}
+	if c.Task == sequenceCopy && c.Window == 1 {
+		return fmt.Errorf("copy task needs a window of at least 2")
+	}
+	if c.TestFraction < 0 || c.TestFraction >= 1 {
+		return fmt.Errorf("test_fraction must be in [0, 1)")
+	}
+	return nil
+}
+
+// SequenceWindow is one window of a sequence task.
+type SequenceWindow struct {
+	Steps    []map[int]float64 // the inputs of each step
+	Expected map[int]float64   // the outputs expected after the last step
+}
+
+// SequenceData holds the training and test windows of a sequence task.
+type SequenceData struct {
+	Train, Test []SequenceWindow
+	Window      int
+	Features    int
+	InputIDs    []int
+	OutputIDs   []int
+	// ClassOutputs and ClassNames are set for classification tasks.
+	ClassOutputs []int
+	ClassNames   []string
+}
+
+// newSequenceData numbers the neurons of windows of the given shape.
+func newSequenceData(window, features, outputs int) *SequenceData {
+	d := &SequenceData{Window: window, Features: features}
+	for f := 0; f < features; f++ {
+		d.InputIDs = append(d.InputIDs, 1+f)
+	}
+	for i := 0; i < outputs; i++ {
+		d.OutputIDs = append(d.OutputIDs, features+1+i)
+	}
+	return d
+}
+
+// window builds a window from step-major input values and output values.
+func (d *SequenceData) window(inputs, outputs []float64) SequenceWindow {
+	w := SequenceWindow{
+		Steps:    make([]map[int]float64, d.Window),
+		Expected: make(map[int]float64, len(d.OutputIDs)),
+	}
+	for t := range w.Steps {
+		w.Steps[t] = make(map[int]float64, d.Features)
+		for f, id := range d.InputIDs {
+			w.Steps[t][id] = inputs[t*d.Features+f]
+		}
+	}
+	for i, id := range d.OutputIDs {
+		w.Expected[id] = outputs[i]
+	}
+	return w
+}
+
+// RunSequence clears the network's state, feeds the steps one RunNetwork call
+// each so that recurrent neurons carry state between them, and returns the
+// outputs after the last step.
+func RunSequence(bp *blueprint.Blueprint, steps []map[int]float64) map[int]float64 {
+	for _, neuron := range bp.Neurons {
+		neuron.Value = 0
+		neuron.CellState = 0
+	}
+	for _, step := range steps {
+		bp.RunNetwork(step, 1)
+	}
+	return bp.GetOutputs()
+}
+
+// loss returns the network's mean loss on the windows: the cross-entropy
+// for classification tasks, the squared error over the outputs otherwise.
+func (d *SequenceData) loss(bp *blueprint.Blueprint, windows []SequenceWindow) float64 {
+	if len(windows) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, w := range windows {
+		outputs := RunSequence(bp, w.Steps)
+		if d.ClassOutputs != nil {
+			sum += CrossEntropy(classLogits(outputs, d.ClassOutputs), argmaxClass(w.Expected, d.ClassOutputs))
+			continue
+		}
+		for _, id := range d.OutputIDs {
+			sum += (outputs[id] - w.Expected[id]) * (outputs[id] - w.Expected[id])
+		}
+	}
+	return sum / float64(len(windows))
+}
+
+// searchSequence hill-climbs on the windows for the given iterations. Each
+// iteration inserts a neuron of one of neuronTypes or mutates the network,
+// and keeps the change only if the loss on the windows drops.
+func searchSequence(bp *blueprint.Blueprint, d *SequenceData, windows []SequenceWindow, neuronTypes []string, iterations int, rng *rand.Rand) error {
+	best := d.loss(bp, windows)
+	for i := 0; i < iterations; i++ {
+		snapshot, err := bp.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
+		}
+
+		if rng.Intn(4) == 0 && len(neuronTypes) > 0 {
+			err = bp.InsertNeuronOfTypeBetweenInputsAndOutputs(neuronTypes[rng.Intn(len(neuronTypes))])
+		} else {
+			err = bp.MutateNetwork()
+		}
+		if err == nil {
+			if loss := d.loss(bp, windows); loss < best {
+				best = loss
+				continue
+			}
+		}
+		if err := RestoreBlueprint(bp, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trainSequence runs the SequenceHillClimbing search in checkpointed rounds
+// and saves the model, skipping the stages a resumed run has completed. If
+// ctx is cancelled the network of the last checkpoint is saved and an error
+// wrapping errInterrupted is returned; bp must not be used after that.
+func trainSequence(ctx context.Context, bp *blueprint.Blueprint, d *SequenceData, run *TrainingRun) error {
+	exp := run.Experiment
+	meta := &run.Metadata
+	meta.InputNodes, meta.OutputNodes = bp.InputNodes, bp.OutputNodes
+	meta.ClassOutputs, meta.ClassNames = d.ClassOutputs, d.ClassNames
+	meta.SequenceWindow, meta.Timesteps = d.Window, 1
+
+	err := trainSequenceStages(ctx, bp, d, run)
+	if errors.Is(err, errInterrupted) {
+		if saveErr := run.SaveBest(); saveErr != nil {
+			log.Printf("Failed to save the best model after the interrupt: %v", saveErr)
+		}
+		return fmt.Errorf("experiment %s stopped at stage %s: %w", exp.Name, run.Stage, err)
+	}
+	return err
+}
+
+// trainSequenceStages runs the pipeline stages for trainSequence.
+func trainSequenceStages(ctx context.Context, bp *blueprint.Blueprint, d *SequenceData, run *TrainingRun) error {
+	exp := run.Experiment
+	if err := run.RestoreInto(bp); err != nil {
+		return err
+	}
+	if run.Blueprint == nil {
+		if err := run.snapshot(bp); err != nil {
+			return err
+		}
+	}
+
+	if run.Stage == stageSearch {
+		fmt.Printf("Training the model with %s...\n", exp.Search.Strategy)
+		searchRound := func(iterations int) error {
+			// Seeding from the round makes a resumed run draw the same changes
+			rng := rand.New(rand.NewSource(run.Metadata.Seed + int64(run.Search.Round)*1000003))
+			return runCancellableErr(ctx, func() error {
+				return searchSequence(bp, d, d.Train, exp.Search.NeuronTypes, iterations, rng)
+			})
+		}
+		checkpoint := func() error {
+			log.Printf("Training loss: %.6f", d.loss(bp, d.Train))
+			return run.Save(bp)
+		}
+		if err := runInChunks(&run.Search, exp.Search.MaxIterations, exp.CheckpointEvery, searchRound, checkpoint); err != nil {
+			return err
+		}
+		if err := run.Advance(bp, stagePostProcessing); err != nil {
+			return err
+		}
+	}
+
+	if run.Stage == stagePostProcessing {
+		if err := os.MkdirAll(filepath.Dir(exp.Output), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := SaveModelJSON(bp, exp.Output, run.Metadata); err != nil {
+			return fmt.Errorf("failed to save model: %w", err)
+		}
+		fmt.Printf("\nTraining complete. Model saved to %s\n", exp.Output)
+		if err := run.Advance(bp, stageEvaluation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSequenceExperiment trains a network on a sequence dataset and reports
+// how well it does on the held-out windows.
+func runSequenceExperiment(rc *RunContext, run *TrainingRun) error {
+	exp := run.Experiment
+	d, err := LoadSequence(*exp.Dataset.Sequence)
+	if err != nil {
+		return err
+	}
+	bp := rc.NewBlueprint()
+	setupIONeurons(bp, d.InputIDs, d.OutputIDs)
+	if err := trainSequence(rc.Context, bp, d, run); err != nil {
+		return err
+	}
+
+	fmt.Printf("Testing the final model of experiment %s:\n", exp.Name)
+	for _, w := range d.Test[:min(len(d.Test), 10)] {
+		fmt.Printf("Steps: %v, Expected Output: %v, Predicted Output: %v\n", w.Steps, w.Expected, RunSequence(bp, w.Steps))
+	}
+
+	fmt.Printf("\nEvaluating on %d held-out windows...\n", len(d.Test))
+	if d.ClassOutputs != nil {
+		report := NewClassificationReport(len(d.ClassOutputs), defaultTopK)
+		report.ClassNames = d.ClassNames
+		for _, w := range d.Test {
+			report.Add(classLogits(RunSequence(bp, w.Steps), d.ClassOutputs), argmaxClass(w.Expected, d.ClassOutputs))
+		}
+		report.Print(os.Stdout)
+	} else {
+		train := make([]map[int]float64, len(d.Train))
+		for i, w := range d.Train {
+			train[i] = w.Expected
+		}
+		report := NewRegressionReport(d.OutputIDs, meanOutputs(train, d.OutputIDs))
+		for _, w := range d.Test {
+			report.Add(RunSequence(bp, w.Steps), w.Expected)
+		}
+		report.Print(os.Stdout)
+	}
+	return run.Advance(bp, stageDone)
+}
+
+// LoadSequence builds the windows of the task described by cfg.
+func LoadSequence(cfg SequenceConfig) (*SequenceData, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Window == 0 {
+		cfg.Window = defaultSequenceWindow
+	}
+	if cfg.Samples == 0 {
+		cfg.Samples = defaultSequenceSamples
+	}
+
+	var (
+		data *SequenceData
+		err  error
+	)
+	switch cfg.Task {
+	case sequenceSeries:
+		data, err = loadSeries(cfg)
+	default:
+		data = generateSequenceTask(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Built %d training and %d test windows of %d steps (%d features, %d outputs) for the %s task.",
+		len(data.Train), len(data.Test), data.Window, data.Features, len(data.OutputIDs), cfg.Task)
+	return data, nil
+}
+
+// generateSequenceTask draws the training and test sessions of a synthetic task.
+func generateSequenceTask(cfg SequenceConfig) *SequenceData {
+	var data *SequenceData
+	switch cfg.Task {
+	case sequenceSine:
+		data = newSequenceData(cfg.Window, 1, 1)
+	case sequenceCopy:
+		data = newSequenceData(cfg.Window, 1, cfg.Window/2)
+	case sequenceParity:
+		data = newSequenceData(cfg.Window, 1, 2)
+		data.ClassOutputs = data.OutputIDs
+		data.ClassNames = []string{"even", "odd"}
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	draw := func(n int) []SequenceWindow {
+		windows := make([]SequenceWindow, n)
+		for i := range windows {
+			inputs, outputs := drawSequence(cfg.Task, cfg.Window, rng)
+			windows[i] = data.window(inputs, outputs)
+		}
+		return windows
+	}
+	data.Train = draw(cfg.Samples)
+	data.Test = draw(max(cfg.Samples/5, 1))
+	return data
+}
+
+// drawSequence draws one window of a synthetic task.
+func drawSequence(task string, window int, rng *rand.Rand) (inputs, outputs []float64) {
+	inputs = make([]float64, window)
+	switch task {
+	case sequenceSine:
+		// A wave with a random phase and a period of 8 to 24 steps
+		phase := rng.Float64() * 2 * math.Pi
+		step := 2 * math.Pi / (8 + rng.Float64()*16)
+		for t := range inputs {
+			inputs[t] = math.Sin(phase + float64(t)*step)
+		}
+		outputs = []float64{math.Sin(phase + float64(window)*step)}
+
+	case sequenceCopy:
+		// Random bits in the first half, blanks in the second; the outputs
+		// are the bits, which must be carried past the blanks
+		outputs = make([]float64, window/2)
+		for t := range outputs {
+			inputs[t] = float64(rng.Intn(2))
+			outputs[t] = inputs[t]
+		}
+
+	case sequenceParity:
+		ones := 0
+		for t := range inputs {
+			bit := rng.Intn(2)
+			inputs[t] = float64(bit)
+			ones += bit
+		}
+		outputs = []float64{1, 0}
+		if ones%2 == 1 {
+			outputs = []float64{0, 1}
+		}
+	}
+	return inputs, outputs
+}
+
+// loadSeries reads a CSV or TSV file of numeric columns, one row per step,
+// and cuts it into windows. The last windows are kept for testing so the
+// model is evaluated on the part of the series it has not seen.
+func loadSeries(cfg SequenceConfig) (*SequenceData, error) {
+	header, rows, err := readTable(TabularConfig{Path: cfg.Path, Delimiter: cfg.Delimiter})
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[name] = i
+	}
+
+	features := cfg.Features
+	if len(features) == 0 {
+		features = header
+	}
+	targets := cfg.Targets
+	if len(targets) == 0 {
+		targets = features
+	}
+	horizon := cfg.Horizon
+	if horizon == 0 {
+		horizon = 1
+	}
+	testFraction := cfg.TestFraction
+	if testFraction == 0 {
+		testFraction = 0.2
+	}
+
+	// Parse the columns that are used
+	values := make(map[string][]float64)
+	for _, name := range append(append([]string{}, features...), targets...) {
+		i, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("column %q not found in %s", name, cfg.Path)
+		}
+		if _, done := values[name]; done {
+			continue
+		}
+		column := make([]float64, len(rows))
+		for r, row := range rows {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %q is not a number", r+2, name, row[i])
+			}
+			column[r] = v
+		}
+		values[name] = column
+	}
+
+	count := len(rows) - cfg.Window - horizon + 1
+	if count < 2 {
+		return nil, fmt.Errorf("%s has %d rows, too few for windows of %d steps and a horizon of %d", cfg.Path, len(rows), cfg.Window, horizon)
+	}
+
+	data := newSequenceData(cfg.Window, len(features), len(targets))
+	inputs := make([]float64, cfg.Window*len(features))
+	outputs := make([]float64, len(targets))
+	windows := make([]SequenceWindow, count)
+	for start := range windows {
+		for t := 0; t < cfg.Window; t++ {
+			for f, name := range features {
+				inputs[t*len(features)+f] = values[name][start+t]
+			}
+		}
+		for k, name := range targets {
+			outputs[k] = values[name][start+cfg.Window-1+horizon]
+		}
+		windows[start] = data.window(inputs, outputs)
+	}
+
+	split := count - int(float64(count)*testFraction)
+	split = min(max(split, 1), count-1)
+	data.Train, data.Test = windows[:split], windows[split:]
+	return data, nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequestBytes bounds the body of a predict request.
+const maxRequestBytes = 32 << 20
+
+// modelPool holds clones of one loaded model. A Blueprint keeps its
+// activations between RunNetwork and GetOutputs, so every request borrows a
+// clone of its own and returns it when done.
+type modelPool struct {
+	name    string
+	path    string
+	modTime time.Time
+	loaded  time.Time
+	model   *Model // the copy clones are made from; never run
+	clones  chan *Model
+}
+
+// newModelPool loads the model file and makes size clones of it.
+func newModelPool(name, path string, size int) (*modelPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	model, err := LoadModelJSON(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &modelPool{
+		name:    name,
+		path:    path,
+		modTime: info.ModTime(),
+		loaded:  time.Now(),
+		model:   model,
+		clones:  make(chan *Model, size),
+	}
+	for i := 0; i < size; i++ {
+		clone, err := model.Clone()
+		if err != nil {
+			return nil, err
+		}
+		pool.clones <- clone
+	}
+	return pool, nil
+}
+
+// predict runs the inputs on a borrowed clone, waiting for one to be free
+// until ctx is done. A panicking model is reported as an error, and its clone
+// is replaced with a fresh one.
+func (p *modelPool) predict(ctx context.Context, inputs []PredictionInput, timesteps int) (predictions []Prediction, err error) {
+	var model *Model
+	select {
+	case model = <-p.clones:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Model %s panicked: %v", p.name, r)
+			err = fmt.Errorf("model %s failed: %v", p.name, r)
+			if clone, cloneErr := p.model.Clone(); cloneErr == nil {
+				model = clone
+			}
+		}
+		p.clones <- model
+	}()
+
+	predictions = make([]Prediction, len(inputs))
+	for i, in := range inputs {
+		predictions[i] = model.Predict(in, timesteps)
+	}
+	return predictions, nil
+}
+
+// ModelServer serves predictions of a set of models over HTTP and reloads a
+// model when its file changes.
+type ModelServer struct {
+	poolSize int
+
+	mu    sync.RWMutex
+	pools map[string]*modelPool
+}
+
+// NewModelServer loads every model in paths, keyed by the name it is served
+// under, with poolSize clones each.
+func NewModelServer(paths map[string]string, poolSize int) (*ModelServer, error) {
+	s := &ModelServer{poolSize: poolSize, pools: make(map[string]*modelPool)}
+	for name, path := range paths {
+		pool, err := newModelPool(name, path, poolSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load model %s: %w", name, err)
+		}
+		s.pools[name] = pool
+		log.Printf("Serving %s from %s with %d clones.", name, path, poolSize)
+	}
+	return s, nil
+}
+
+// pool returns the current pool of the named model.
+func (s *ModelServer) pool(name string) (*modelPool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pool, ok := s.pools[name]
+	return pool, ok
+}
+
+// WatchFiles checks the model files every interval until ctx is done and
+// swaps in a freshly loaded pool when a file's modification time changes.
+// Requests already running finish on the old clones. A file that fails to
+// load keeps the previous version in service.
+func (s *ModelServer) WatchFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.RLock()
+		pools := make([]*modelPool, 0, len(s.pools))
+		for _, pool := range s.pools {
+			pools = append(pools, pool)
+		}
+		s.mu.RUnlock()
+
+		for _, old := range pools {
+			info, err := os.Stat(old.path)
+			if err != nil || info.ModTime().Equal(old.modTime) {
+				continue
+			}
+			pool, err := newModelPool(old.name, old.path, s.poolSize)
+			if err != nil {
+				log.Printf("Failed to reload %s, keeping the previous version: %v", old.name, err)
+				// Do not retry until the file changes again
+				old.modTime = info.ModTime()
+				continue
+			}
+			s.mu.Lock()
+			s.pools[old.name] = pool
+			s.mu.Unlock()
+			log.Printf("Reloaded %s from %s.", old.name, old.path)
+		}
+	}
+}
+
+// ServeHTTP routes:
+//
+//	GET  /healthz                      liveness check
+//	GET  /v1/models                    the served models
+//	POST /v1/models/{name}:predict     predictions of one model
+func (s *ModelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case r.URL.Path == "/v1/models":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"models": s.describe()})
+	case strings.HasPrefix(r.URL.Path, "/v1/models/") && strings.HasSuffix(r.URL.Path, ":predict"):
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/models/"), ":predict")
+		s.handlePredict(w, r, name)
+	default:
+		writeError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+	}
+}
+
+// modelInfo describes a served model in GET /v1/models.
+type modelInfo struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Loaded     time.Time `json:"loaded"`
+	Inputs     int       `json:"inputs"`
+	Outputs    int       `json:"outputs"`
+	Timesteps  int       `json:"timesteps,omitempty"`
+	ClassNames []string  `json:"class_names,omitempty"`
+}
+
+// describe lists the served models sorted by name.
+func (s *ModelServer) describe() []modelInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]modelInfo, 0, len(s.pools))
+	for _, pool := range s.pools {
+		m := pool.model
+		infos = append(infos, modelInfo{
+			Name:       pool.name,
+			Path:       pool.path,
+			Loaded:     pool.loaded,
+			Inputs:     len(m.InputIDs()),
+			Outputs:    len(m.Blueprint.OutputNodes),
+			Timesteps:  m.Metadata.Timesteps,
+			ClassNames: m.Metadata.ClassNames,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// handlePredict answers POST /v1/models/{name}:predict. The body is either
+// JSON as read by decodeJSONInputs, or a raw PNG or JPEG image. The optional
+// query parameter timesteps overrides the model's default.
+func (s *ModelServer) handlePredict(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	pool, ok := s.pool(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown model %q", name))
+		return
+	}
+
+	var timesteps int
+	if v := r.URL.Query().Get("timesteps"); v != "" {
+		var err error
+		if timesteps, err = strconv.Atoi(v); err != nil || timesteps <= 0 {
+			writeError(w, http.StatusBadRequest, "timesteps must be a positive integer")
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	var inputs []PredictionInput
+	if contentType := r.Header.Get("Content-Type"); strings.HasPrefix(contentType, "image/") {
+		in, err := imageInput(bytes.NewReader(body), "image", pool.model.InputIDs())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		inputs = []PredictionInput{in}
+	} else if inputs, err = decodeJSONInputs(body, "request"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	predictions, err := pool.predict(r.Context(), inputs, timesteps)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if r.Context().Err() != nil {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"model":       name,
+		"predictions": predictions,
+	})
+}
+
+// writeJSON writes v as the JSON response body. It is encoded before the
+// status is sent, so a value that cannot be encoded gives a 500 response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		body.Reset()
+		status = http.StatusInternalServerError
+		body.WriteString(`{"error":"failed to encode the response"}` + "\n")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// modelFlags collects repeated --model name=path flags; a bare path is
+// served under its file name without the extension.
+type modelFlags map[string]string
+
+func (f modelFlags) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, path := range f {
+		pairs = append(pairs, name+"="+path)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f modelFlags) Set(value string) error {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok {
+		path = value
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if name == "" || path == "" {
+		return fmt.Errorf("expected name=path or a path, got %q", value)
+	}
+	if _, dup := f[name]; dup {
+		return fmt.Errorf("model %q given twice", name)
+	}
+	f[name] = path
+	return nil
+}
+
+// serveModels runs the server on addr until ctx is done, then shuts it down
+// gracefully.
+func serveModels(ctx context.Context, addr string, server *ModelServer, reload time.Duration) error {
+	if reload > 0 {
+		go server.WatchFiles(ctx, reload)
+	}
+	return listenAndServe(ctx, addr, server)
+}
+
+// listenAndServe serves handler on addr until ctx is done, then waits up to
+// ten seconds for requests in flight to finish.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	httpServer := &http.Server{Addr: addr, Handler: handler}
+	errc := make(chan error, 1)
+	go func() { errc <- httpServer.ListenAndServe() }()
+	log.Printf("Listening on %s", addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdown); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped.")
+	return nil
+}
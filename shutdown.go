@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errInterrupted is returned when a run was stopped by SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// newSignalContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM; action tells the user what happens then. After that the default
+// handlers are restored, so a second Ctrl-C terminates the process immediately.
+func newSignalContext(action string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, %s (press Ctrl-C again to exit immediately)...", sig, action)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// runCancellable runs fn and waits for it to return or for ctx to be
+// cancelled. The Blueprint search methods cannot be interrupted, so after a
+// cancellation fn keeps running in the background until the process exits;
+// callers must not touch the network it works on afterwards.
+func runCancellable(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errInterrupted
+	}
+}
+
+// runCancellableErr is runCancellable for a function that can fail.
+func runCancellableErr(ctx context.Context, fn func() error) error {
+	var err error
+	if cancelled := runCancellable(ctx, func() { err = fn() }); cancelled != nil {
+		return cancelled
+	}
+	return err
+}
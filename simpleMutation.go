@@ -1,11 +1,10 @@
 package main
 
 import (
-	"blueprint"
 	"fmt"
 )
 
-func testMutations() {
+func testMutations(rc *RunContext) error {
 	// Example JSON configuration for a simple network
 	const neuronConfig = `
 		[
@@ -17,13 +16,12 @@ func testMutations() {
 	`
 
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -33,8 +31,7 @@ func testMutations() {
 	// Insert a new neuron of type "lstm" between inputs and outputs
 	err = bp.InsertNeuronOfTypeBetweenInputsAndOutputs("lstm")
 	if err != nil {
-		fmt.Printf("Error inserting neuron: %v\n", err)
-		return
+		return fmt.Errorf("failed to insert neuron: %w", err)
 	}
 
 	// Define inputs
@@ -46,9 +43,10 @@ func testMutations() {
 	// Run the network with specified timesteps
 	fmt.Println("---Running Network After Inserting LSTM Neuron---")
 	bp.RunNetwork(inputs, 3)
+	return nil
 }
 
-func testMutationsWithMultipleTypes() {
+func testMutationsWithMultipleTypes(rc *RunContext) error {
 	// Example JSON configuration for a simple network
 	const neuronConfig = `
 		[
@@ -60,13 +58,12 @@ func testMutationsWithMultipleTypes() {
 	`
 
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -76,8 +73,7 @@ func testMutationsWithMultipleTypes() {
 	// Perform multiple mutations: insert one neuron of each supported type
 	err = bp.MutateNetwork()
 	if err != nil {
-		fmt.Printf("Error mutating network: %v\n", err)
-		return
+		return fmt.Errorf("failed to mutate network: %w", err)
 	}
 
 	// Define inputs
@@ -89,9 +85,10 @@ func testMutationsWithMultipleTypes() {
 	// Run the network with specified timesteps
 	fmt.Println("---Running Network After Multiple Mutations---")
 	bp.RunNetwork(inputs, 5)
+	return nil
 }
 
-func testAllNeuronTypes() {
+func testAllNeuronTypes(rc *RunContext) error {
 	// Example JSON configuration for a simple network
 	const neuronConfig = `
 		[
@@ -103,13 +100,12 @@ func testAllNeuronTypes() {
 	`
 
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -132,8 +128,7 @@ func testAllNeuronTypes() {
 	for _, neuronType := range neuronTypes {
 		err = bp.InsertNeuronOfTypeBetweenInputsAndOutputs(neuronType)
 		if err != nil {
-			fmt.Printf("Error inserting neuron of type '%s': %v\n", neuronType, err)
-			return
+			return fmt.Errorf("failed to insert neuron of type '%s': %w", neuronType, err)
 		}
 	}
 
@@ -146,4 +141,5 @@ func testAllNeuronTypes() {
 	// Run the network with specified timesteps
 	fmt.Println("---Running Network After Inserting All Neuron Types---")
 	bp.RunNetwork(inputs, 5)
+	return nil
 }
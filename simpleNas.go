@@ -3,16 +3,11 @@ package main
 import (
 	"blueprint"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
-func simpleNAS() {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
+func simpleNAS(rc *RunContext) error {
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Define input and output nodes
 	bp.AddInputNodes([]int{1, 2})
@@ -89,18 +84,15 @@ func simpleNAS() {
 	fmt.Println("Final model:")
 	jsonStr, err := bp.ToJSON()
 	if err != nil {
-		fmt.Printf("Error converting blueprint to JSON: %v\n", err)
-	} else {
-		fmt.Println(jsonStr)
+		return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
 	}
+	fmt.Println(jsonStr)
+	return nil
 }
 
-func simpleNASWithoutCrossover() {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
+func simpleNASWithoutCrossover(rc *RunContext) error {
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Define input and output nodes
 	bp.AddInputNodes([]int{1, 2})
@@ -183,44 +175,20 @@ func simpleNASWithoutCrossover() {
 	fmt.Println("Final model:")
 	jsonStr, err := bp.ToJSON()
 	if err != nil {
-		fmt.Printf("Error converting blueprint to JSON: %v\n", err)
-	} else {
-		fmt.Println(jsonStr)
+		return fmt.Errorf("failed to convert blueprint to JSON: %w", err)
 	}
+	fmt.Println(jsonStr)
+	return nil
 }
 
-func testWithRandomConnections() {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
-	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
-	bp.Debug = false
-
-	// Define input and output nodes
-	bp.AddInputNodes([]int{1, 2})
-	bp.AddOutputNodes([]int{3})
-
-	// Add input neurons to the blueprint
-	bp.Neurons[1] = &blueprint.Neuron{
-		ID:   1,
-		Type: "input",
-	}
-	bp.Neurons[2] = &blueprint.Neuron{
-		ID:   2,
-		Type: "input",
-	}
-
-	// Add output neuron to the blueprint
-	bp.Neurons[3] = &blueprint.Neuron{
-		ID:          3,
-		Type:        "output",
-		Activation:  "linear",
-		Connections: [][]float64{}, // No initial connections
-	}
+func testWithRandomConnections(rc *RunContext) error {
+	return runExperiment(rc, DefaultRandomConnectionsExperiment())
+}
 
-	// Define a simple linear relationship dataset
-	sessions := []blueprint.Session{
+// linearSumSessions returns a simple linear relationship dataset in which the
+// output equals the sum of the two inputs.
+func linearSumSessions() []blueprint.Session {
+	return []blueprint.Session{
 		{
 			InputVariables: map[int]float64{
 				1: 1.0,
@@ -262,49 +230,4 @@ func testWithRandomConnections() {
 			Timesteps: 1,
 		},
 	}
-
-	// Set parameters for SimpleNASWithRandomConnections
-	maxIterations := 100000
-	forgivenessThreshold := 0.05 // 5%
-
-	neuronTypes := []string{
-		"dense",
-		"rnn",
-		//"lstm",
-		"cnn",
-		"dropout",
-		"batch_norm",
-		"attention",
-		"nca",
-	}
-
-	weightUpdateIterations := 10 // Number of weight update steps per NAS iteration
-
-	// Perform NAS
-	bp.SimpleNASWithRandomConnections(sessions, maxIterations, forgivenessThreshold, neuronTypes, weightUpdateIterations)
-	//bp.SimpleNASWithRandomConnections(sessions, maxIterations, forgivenessThreshold, neuronTypes)
-
-	// Test the final model
-	fmt.Println("Testing the final model on the hammer test with random connections:")
-	for _, session := range sessions {
-		bp.RunNetwork(session.InputVariables, session.Timesteps)
-		predictedOutput := bp.GetOutputs()
-		fmt.Printf("Input: %v, Expected Output: %v, Predicted Output: %v\n",
-			session.InputVariables, session.ExpectedOutput, predictedOutput)
-	}
-
-	err := bp.SaveToJSON("output/nastest.json")
-	if err != nil {
-		fmt.Printf("Error saving Blueprint to file: %v\n", err)
-		return
-	}
-
-	// Display the final model as JSON
-	/*fmt.Println("Final model structure:")
-	jsonStr, err := bp.ToJSON()
-	if err != nil {
-		fmt.Printf("Error converting blueprint to JSON: %v\n", err)
-	} else {
-		fmt.Println(jsonStr)
-	}*/
 }
@@ -3,11 +3,9 @@ package main
 import (
 	"blueprint"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
-func testNeuroCellularAutomata() {
+func testNeuroCellularAutomata(rc *RunContext) error {
 	// Example JSON configuration for a network with NCA neurons
 	const neuronConfig = `
 		[
@@ -20,16 +18,13 @@ func testNeuroCellularAutomata() {
 		]
 	`
 
-	rand.Seed(time.Now().UnixNano())
-
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -45,9 +40,10 @@ func testNeuroCellularAutomata() {
 	// Run the network with specified timesteps
 	fmt.Println("Running Neuro Cellular Automata Test...")
 	bp.RunNetwork(inputs, 5) // Adjust timesteps for NCA to observe temporal evolution
+	return nil
 }
 
-func testFullRangeOfNeuronsNCA() {
+func testFullRangeOfNeuronsNCA(rc *RunContext) error {
 	// Updated JSON configuration for a network with all neuron types
 	const neuronConfig = `
 		[
@@ -65,16 +61,13 @@ func testFullRangeOfNeuronsNCA() {
 		]
 	`
 
-	rand.Seed(time.Now().UnixNano())
-
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -90,9 +83,10 @@ func testFullRangeOfNeuronsNCA() {
 	// Run the network with specified timesteps
 	fmt.Println("---Testing Full Range of Neurons---")
 	bp.RunNetwork(inputs, 5)
+	return nil
 }
 
-func testNeuroCellularAutomataWithCNNKernels() {
+func testNeuroCellularAutomataWithCNNKernels(rc *RunContext) error {
 	fmt.Println("---NeuroCellularAutomataWithCNNKernels---")
 
 	// Example JSON configuration for a network with CNN neurons having multiple kernels and NCA neurons
@@ -129,17 +123,14 @@ func testNeuroCellularAutomataWithCNNKernels() {
     ]
     `
 
-	rand.Seed(time.Now().UnixNano())
-
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 	bp.ScalarActivationMap = blueprint.InitializeActivationFunctions()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -155,4 +146,5 @@ func testNeuroCellularAutomataWithCNNKernels() {
 	// Run the network with specified timesteps
 	fmt.Println("Running Neuro Cellular Automata with CNN Kernels Test...")
 	bp.RunNetwork(inputs, 3) // Adjust timesteps as needed
+	return nil
 }
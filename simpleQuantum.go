@@ -2,18 +2,13 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
-	"time"
 
 	"blueprint" // Import the blueprint package
 )
 
-func RunQuantumExample() {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
+func RunQuantumExample(rc *RunContext) error {
 	// Initialize the neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Create quantum neurons
 	quantumNeuron1 := &blueprint.QuantumNeuron{
@@ -64,9 +59,10 @@ func RunQuantumExample() {
 	// Output the results
 	fmt.Printf("Quantum Neuron %d final state: Amplitude=%v, Phase=%f\n", quantumNeuron1.ID, quantumNeuron1.QuantumState.Amplitude, quantumNeuron1.QuantumState.Phase)
 	fmt.Printf("Quantum Neuron %d final state: Amplitude=%v, Phase=%f\n", quantumNeuron2.ID, quantumNeuron2.QuantumState.Amplitude, quantumNeuron2.QuantumState.Phase)
+	return nil
 }
 
-func RunQuantumExampleWithIntegration() {
+func RunQuantumExampleWithIntegration(rc *RunContext) error {
 
 	// Example JSON configuration for the neural network
 	const neuronConfig = `
@@ -83,17 +79,13 @@ func RunQuantumExampleWithIntegration() {
 ]
 `
 
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
 	// Initialize the neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load existing neurons from JSON configuration (if any)
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes for the classical network
@@ -158,15 +150,13 @@ func RunQuantumExampleWithIntegration() {
 	if neuron, exists := bp.Neurons[1]; exists && neuron.Type == "input" {
 		neuron.Value = measuredValue1
 	} else {
-		fmt.Printf("Input Neuron %d not found or not an input neuron.\n", 1)
-		return
+		return fmt.Errorf("input neuron %d not found or not an input neuron", 1)
 	}
 
 	if neuron, exists := bp.Neurons[2]; exists && neuron.Type == "input" {
 		neuron.Value = measuredValue2
 	} else {
-		fmt.Printf("Input Neuron %d not found or not an input neuron.\n", 2)
-		return
+		return fmt.Errorf("input neuron %d not found or not an input neuron", 2)
 	}
 
 	// Run the classical network for a specified number of timesteps
@@ -179,4 +169,5 @@ func RunQuantumExampleWithIntegration() {
 	for id, value := range outputs {
 		fmt.Printf("Neuron %d: %f\n", id, value)
 	}
+	return nil
 }
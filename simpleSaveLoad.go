@@ -1,11 +1,10 @@
 package main
 
 import (
-	"blueprint"
 	"fmt"
 )
 
-func createAndSaveAllNeuronTypesToFile(destination string) {
+func createAndSaveAllNeuronTypesToFile(rc *RunContext, destination string) error {
 	// Define the neuron types to be included
 	neuronTypes := []string{
 		"dense",
@@ -19,7 +18,7 @@ func createAndSaveAllNeuronTypesToFile(destination string) {
 	}
 
 	// Initialize a new blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Add dummy input and output neurons for testing
 	bp.AddInputNodes([]int{1, 2})
@@ -29,17 +28,16 @@ func createAndSaveAllNeuronTypesToFile(destination string) {
 	for _, neuronType := range neuronTypes {
 		err := bp.InsertNeuronOfTypeBetweenInputsAndOutputs(neuronType)
 		if err != nil {
-			fmt.Printf("Error inserting neuron of type '%s': %v\n", neuronType, err)
-			return
+			return fmt.Errorf("failed to insert neuron of type '%s': %w", neuronType, err)
 		}
 	}
 
 	// Save the network as JSON to the specified destination
-	err := bp.SaveToJSON(destination)
+	err := SaveModelJSON(bp, destination, rc.Metadata())
 	if err != nil {
-		fmt.Printf("Error saving Blueprint to file: %v\n", err)
-		return
+		return fmt.Errorf("failed to save blueprint to file: %w", err)
 	}
 
 	fmt.Printf("Neural network with all neuron types saved successfully to '%s'\n", destination)
+	return nil
 }
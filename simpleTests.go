@@ -1,13 +1,10 @@
 package main
 
 import (
-	"blueprint"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
-func simple1() {
+func simple1(rc *RunContext) error {
 	// Example JSON configuration for the neural network
 	const neuronConfig = `
 		[
@@ -23,16 +20,13 @@ func simple1() {
 		]
 		`
 
-	rand.Seed(time.Now().UnixNano())
-
 	// Initialize neural network blueprint
-	bp := blueprint.NewBlueprint()
+	bp := rc.NewBlueprint()
 
 	// Load neurons from JSON configuration
 	err := bp.LoadNeurons(neuronConfig)
 	if err != nil {
-		fmt.Printf("Error loading neurons: %v\n", err)
-		return
+		return fmt.Errorf("failed to load neurons: %w", err)
 	}
 
 	// Define input and output nodes
@@ -47,4 +41,5 @@ func simple1() {
 
 	// Run the network with specified timesteps for recurrent networks
 	bp.RunNetwork(inputs, 3)
+	return nil
 }
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"blueprint"
+)
+
+// Missing-value strategies of a tabular dataset.
+const (
+	missingImpute = "impute" // numbers get the column mean, categories the most frequent value
+	missingZero   = "zero"   // numbers get 0, categories no one-hot bit
+	missingDrop   = "drop"   // rows with a missing value are skipped
+)
+
+// Feature normalisations of a tabular dataset.
+const (
+	normalizeNone   = "none"
+	normalizeMinMax = "minmax" // scale to [0, 1] using the column's range
+	normalizeZScore = "zscore" // subtract the mean and divide by the standard deviation
+)
+
+// TabularConfig describes a CSV or TSV file with a header row.
+type TabularConfig struct {
+	Path string `json:"path"`
+	// Delimiter defaults to a tab for .tsv files and a comma otherwise.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Features lists the input columns; by default every column that is not a target.
+	Features []string `json:"features,omitempty"`
+	Targets  []string `json:"targets"`
+	// Categorical lists columns to one-hot encode. Columns holding values
+	// that are not numbers are treated as categorical anyway.
+	Categorical []string `json:"categorical,omitempty"`
+	// Missing is "impute" (default), "zero" or "drop". Rows with a missing
+	// target are always dropped.
+	Missing string `json:"missing,omitempty"`
+	// Normalize is "none" (default), "minmax" or "zscore" and applies to
+	// numeric features only.
+	Normalize string `json:"normalize,omitempty"`
+	// Stats, when set, is the file the column statistics are kept in. If it
+	// exists the statistics are read from it instead of being fitted, so a
+	// test set is encoded exactly like the training set.
+	Stats string `json:"stats,omitempty"`
+}
+
+// Validate checks the options that do not depend on the file's contents.
+func (c TabularConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("tabular dataset needs a path")
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("tabular dataset needs at least one target column")
+	}
+	switch c.Missing {
+	case "", missingImpute, missingZero, missingDrop:
+	default:
+		return fmt.Errorf("unknown missing-value strategy %q", c.Missing)
+	}
+	switch c.Normalize {
+	case "", normalizeNone, normalizeMinMax, normalizeZScore:
+	default:
+		return fmt.Errorf("unknown normalization %q", c.Normalize)
+	}
+	if len([]rune(c.Delimiter)) > 1 {
+		return fmt.Errorf("delimiter must be a single character, got %q", c.Delimiter)
+	}
+	return nil
+}
+
+// ColumnStats holds what is needed to encode one column the same way again.
+type ColumnStats struct {
+	Name        string   `json:"name"`
+	Categorical bool     `json:"categorical,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+	Min         float64  `json:"min"`
+	Max         float64  `json:"max"`
+	Mean        float64  `json:"mean"`
+	Std         float64  `json:"std"`
+	// Fill replaces missing values: a number, or a category name.
+	Fill string `json:"fill"`
+}
+
+// TabularStats is the persisted encoding of a tabular dataset.
+type TabularStats struct {
+	Normalize string        `json:"normalize"`
+	Columns   []ColumnStats `json:"columns"`
+}
+
+// column returns the statistics of the named column.
+func (s *TabularStats) column(name string) (ColumnStats, bool) {
+	for _, c := range s.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ColumnStats{}, false
+}
+
+// TabularEncoding is what turns a row of the original file into the inputs
+// of a model trained on it. It is saved with the model.
+type TabularEncoding struct {
+	Features []string      `json:"features"`
+	Missing  string        `json:"missing,omitempty"`
+	Stats    *TabularStats `json:"stats"`
+}
+
+// Encode converts the feature cells of a row, keyed by column name, into
+// input values on neurons numbered from 1 like LoadTabular numbers them.
+func (e *TabularEncoding) Encode(cells map[string]string) (map[int]float64, error) {
+	inputs := make(map[int]float64)
+	for _, name := range e.Features {
+		col, ok := e.Stats.column(name)
+		if !ok {
+			return nil, fmt.Errorf("the encoding has no statistics for column %q", name)
+		}
+		v, ok := cells[name]
+		if !ok {
+			return nil, fmt.Errorf("column %q is missing", name)
+		}
+		if isMissingValue(v) && e.Missing == missingDrop {
+			return nil, fmt.Errorf("column %s has no value", name)
+		}
+		encoded, err := encodeCell(v, col, e.Stats.Normalize, true, e.Missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range encoded {
+			inputs[len(inputs)+1] = value
+		}
+	}
+	return inputs, nil
+}
+
+// TabularData is a tabular file converted into sessions. Inputs are numbered
+// from 1 and outputs follow the last input, one neuron per numeric column and
+// one per category of a categorical column.
+type TabularData struct {
+	Sessions    []blueprint.Session
+	InputIDs    []int
+	OutputIDs   []int
+	InputNames  []string // "column" or "column=category", aligned with InputIDs
+	OutputNames []string // aligned with OutputIDs
+	// ClassOutputs is set when the only target is categorical, making the
+	// data a classification task over these output neurons.
+	ClassOutputs []int
+	Stats        *TabularStats
+	Encoding     *TabularEncoding
+}
+
+// isMissingValue reports whether a cell holds no value.
+func isMissingValue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "na", "n/a", "nan", "null", "none", "?":
+		return true
+	}
+	return false
+}
+
+// LoadTabular reads the file described by cfg and encodes it as sessions.
+func LoadTabular(cfg TabularConfig) (*TabularData, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	header, rows, err := readTable(cfg)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[name] = i
+	}
+
+	// Resolve the feature and target columns
+	isTarget := make(map[string]bool)
+	for _, name := range cfg.Targets {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("target column %q not found in %s", name, cfg.Path)
+		}
+		isTarget[name] = true
+	}
+	features := cfg.Features
+	if len(features) == 0 {
+		for _, name := range header {
+			if !isTarget[name] {
+				features = append(features, name)
+			}
+		}
+	}
+	for _, name := range features {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("feature column %q not found in %s", name, cfg.Path)
+		}
+		if isTarget[name] {
+			return nil, fmt.Errorf("column %q cannot be both a feature and a target", name)
+		}
+	}
+	columns := append(append([]string{}, features...), cfg.Targets...)
+
+	// Drop rows that cannot be used
+	kept := rows[:0]
+	for _, row := range rows {
+		usable := true
+		for _, name := range columns {
+			if isMissingValue(row[index[name]]) && (isTarget[name] || cfg.Missing == missingDrop) {
+				usable = false
+				break
+			}
+		}
+		if usable {
+			kept = append(kept, row)
+		}
+	}
+	if dropped := len(rows) - len(kept); dropped > 0 {
+		log.Printf("Dropped %d of %d rows with missing values.", dropped, len(rows))
+	}
+	rows = kept
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no usable rows in %s", cfg.Path)
+	}
+
+	stats, err := tabularStats(cfg, columns, index, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &TabularData{
+		Stats:    stats,
+		Encoding: &TabularEncoding{Features: features, Missing: cfg.Missing, Stats: stats},
+	}
+	encoders := make([]ColumnStats, len(columns))
+	for i, name := range columns {
+		col, ok := stats.column(name)
+		if !ok {
+			return nil, fmt.Errorf("statistics in %s have no column %q", cfg.Stats, name)
+		}
+		encoders[i] = col
+
+		names := []string{name}
+		if col.Categorical {
+			names = names[:0]
+			for _, category := range col.Categories {
+				names = append(names, name+"="+category)
+			}
+		}
+		if i < len(features) {
+			data.InputNames = append(data.InputNames, names...)
+		} else {
+			data.OutputNames = append(data.OutputNames, names...)
+		}
+	}
+	for i := range data.InputNames {
+		data.InputIDs = append(data.InputIDs, i+1)
+	}
+	for i := range data.OutputNames {
+		data.OutputIDs = append(data.OutputIDs, len(data.InputIDs)+i+1)
+	}
+	if len(cfg.Targets) == 1 && encoders[len(features)].Categorical {
+		data.ClassOutputs = data.OutputIDs
+	}
+
+	for r, row := range rows {
+		values := make([]float64, 0, len(data.InputIDs)+len(data.OutputIDs))
+		for i, name := range columns {
+			encoded, err := encodeCell(row[index[name]], encoders[i], stats.Normalize, i < len(features), cfg.Missing)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", r+2, err)
+			}
+			values = append(values, encoded...)
+		}
+
+		session := blueprint.Session{
+			InputVariables: make(map[int]float64, len(data.InputIDs)),
+			ExpectedOutput: make(map[int]float64, len(data.OutputIDs)),
+			Timesteps:      1,
+		}
+		for i, id := range data.InputIDs {
+			session.InputVariables[id] = values[i]
+		}
+		for i, id := range data.OutputIDs {
+			session.ExpectedOutput[id] = values[len(data.InputIDs)+i]
+		}
+		data.Sessions = append(data.Sessions, session)
+	}
+
+	log.Printf("Loaded %d sessions from %s: %d inputs, %d outputs.",
+		len(data.Sessions), cfg.Path, len(data.InputIDs), len(data.OutputIDs))
+	return data, nil
+}
+
+// readTable reads the header and data rows of the file.
+func readTable(cfg TabularConfig) ([]string, [][]string, error) {
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open tabular file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = ','
+	if cfg.Delimiter != "" {
+		reader.Comma = []rune(cfg.Delimiter)[0]
+	} else if strings.EqualFold(filepath.Ext(cfg.Path), ".tsv") {
+		reader.Comma = '\t'
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("%s is empty", cfg.Path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header of %s: %w", cfg.Path, err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", cfg.Path, err)
+	}
+	return header, rows, nil
+}
+
+// tabularStats loads the statistics file named by cfg.Stats if it exists,
+// and otherwise fits the statistics to rows and writes them there.
+func tabularStats(cfg TabularConfig, columns []string, index map[string]int, rows [][]string) (*TabularStats, error) {
+	if cfg.Stats != "" {
+		data, err := os.ReadFile(cfg.Stats)
+		if err == nil {
+			var stats TabularStats
+			if err := json.Unmarshal(data, &stats); err != nil {
+				return nil, fmt.Errorf("failed to decode statistics %s: %w", cfg.Stats, err)
+			}
+			log.Printf("Encoding columns with the statistics in %s.", cfg.Stats)
+			return &stats, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read statistics: %w", err)
+		}
+	}
+
+	normalize := cfg.Normalize
+	if normalize == "" {
+		normalize = normalizeNone
+	}
+	categorical := make(map[string]bool)
+	for _, name := range cfg.Categorical {
+		categorical[name] = true
+	}
+
+	stats := &TabularStats{Normalize: normalize}
+	for _, name := range columns {
+		stats.Columns = append(stats.Columns, fitColumn(name, index[name], rows, categorical[name]))
+	}
+
+	if cfg.Stats != "" {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode statistics: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.Stats), os.ModePerm); err != nil {
+			return nil, fmt.Errorf("failed to create statistics directory: %w", err)
+		}
+		if err := writeFileAtomic(cfg.Stats, data); err != nil {
+			return nil, fmt.Errorf("failed to write statistics: %w", err)
+		}
+		log.Printf("Saved column statistics to %s.", cfg.Stats)
+	}
+	return stats, nil
+}
+
+// fitColumn computes the statistics of column i. The column is categorical
+// when asked to be or when a value is not a number.
+func fitColumn(name string, i int, rows [][]string, categorical bool) ColumnStats {
+	var numbers []float64
+	counts := make(map[string]int)
+	for _, row := range rows {
+		v := strings.TrimSpace(row[i])
+		if isMissingValue(v) {
+			continue
+		}
+		counts[v]++
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			numbers = append(numbers, f)
+		} else {
+			categorical = true
+		}
+	}
+
+	col := ColumnStats{Name: name, Categorical: categorical}
+	if categorical {
+		for category := range counts {
+			col.Categories = append(col.Categories, category)
+		}
+		sort.Strings(col.Categories)
+
+		// The most frequent category fills gaps, the first one on ties
+		for _, category := range col.Categories {
+			if counts[category] > counts[col.Fill] {
+				col.Fill = category
+			}
+		}
+		return col
+	}
+
+	if len(numbers) == 0 {
+		col.Fill = "0"
+		return col
+	}
+	col.Min, col.Max = numbers[0], numbers[0]
+	var sum float64
+	for _, f := range numbers {
+		col.Min = math.Min(col.Min, f)
+		col.Max = math.Max(col.Max, f)
+		sum += f
+	}
+	col.Mean = sum / float64(len(numbers))
+	var sq float64
+	for _, f := range numbers {
+		sq += (f - col.Mean) * (f - col.Mean)
+	}
+	col.Std = math.Sqrt(sq / float64(len(numbers)))
+	col.Fill = strconv.FormatFloat(col.Mean, 'g', -1, 64)
+	return col
+}
+
+// encodeCell turns one cell into its input or output values: one number for
+// a numeric column (normalised if it is a feature), one-hot bits for a
+// categorical one. Unknown categories encode as all zeros.
+func encodeCell(v string, col ColumnStats, normalize string, feature bool, missing string) ([]float64, error) {
+	v = strings.TrimSpace(v)
+	isMissing := isMissingValue(v)
+
+	if col.Categorical {
+		bits := make([]float64, len(col.Categories))
+		if isMissing {
+			if missing == missingZero {
+				return bits, nil
+			}
+			v = col.Fill
+		}
+		for i, category := range col.Categories {
+			if category == v {
+				bits[i] = 1
+			}
+		}
+		return bits, nil
+	}
+
+	if isMissing {
+		if missing == missingZero {
+			return []float64{0}, nil
+		}
+		v = col.Fill
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %q is not a number", col.Name, v)
+	}
+
+	if feature {
+		switch normalize {
+		case normalizeMinMax:
+			if col.Max > col.Min {
+				f = (f - col.Min) / (col.Max - col.Min)
+			} else {
+				f = 0
+			}
+		case normalizeZScore:
+			if col.Std > 0 {
+				f = (f - col.Mean) / col.Std
+			} else {
+				f = 0
+			}
+		}
+	}
+	return []float64{f}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTabularEncodingEncode(t *testing.T) {
+	stats := &TabularStats{
+		Normalize: normalizeMinMax,
+		Columns: []ColumnStats{
+			{Name: "age", Min: 20, Max: 60, Mean: 40, Std: 10, Fill: "40"},
+			{Name: "colour", Categorical: true, Categories: []string{"blue", "red"}, Fill: "red"},
+		},
+	}
+	tests := []struct {
+		name    string
+		missing string
+		cells   map[string]string
+		want    map[int]float64
+		wantErr bool
+	}{
+		{
+			name:  "numbers are normalised and categories one-hot",
+			cells: map[string]string{"age": "30", "colour": "blue"},
+			want:  map[int]float64{1: 0.25, 2: 1, 3: 0},
+		},
+		{
+			name:  "unknown categories are all zeros",
+			cells: map[string]string{"age": "60", "colour": "green"},
+			want:  map[int]float64{1: 1, 2: 0, 3: 0},
+		},
+		{
+			name:    "missing values are imputed",
+			missing: missingImpute,
+			cells:   map[string]string{"age": "NA", "colour": ""},
+			want:    map[int]float64{1: 0.5, 2: 0, 3: 1},
+		},
+		{
+			name:    "missing values become zeros",
+			missing: missingZero,
+			cells:   map[string]string{"age": "?", "colour": "null"},
+			want:    map[int]float64{1: 0, 2: 0, 3: 0},
+		},
+		{
+			name:    "missing values are rejected when rows are dropped",
+			missing: missingDrop,
+			cells:   map[string]string{"age": "", "colour": "red"},
+			wantErr: true,
+		},
+		{
+			name:    "missing column",
+			cells:   map[string]string{"age": "30"},
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			cells:   map[string]string{"age": "old", "colour": "red"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &TabularEncoding{Features: []string{"age", "colour"}, Missing: tt.missing, Stats: stats}
+			got, err := e.Encode(tt.cells)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeCellZScore(t *testing.T) {
+	col := ColumnStats{Name: "x", Mean: 10, Std: 2}
+	tests := []struct {
+		in      string
+		feature bool
+		want    float64
+	}{
+		{"14", true, 2},
+		{"14", false, 14}, // targets are not normalised
+		{"6", true, -2},
+	}
+	for _, tt := range tests {
+		got, err := encodeCell(tt.in, col, normalizeZScore, tt.feature, missingImpute)
+		if err != nil || len(got) != 1 || got[0] != tt.want {
+			t.Errorf("encodeCell(%q, feature %v) = %v, %v; want [%g]", tt.in, tt.feature, got, err, tt.want)
+		}
+	}
+}